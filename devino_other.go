@@ -0,0 +1,12 @@
+//go:build !unix
+
+package maildir
+
+import (
+	"os"
+)
+
+// fileDevIno returns the device and inode numbers of a file.
+func fileDevIno(fi os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
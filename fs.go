@@ -0,0 +1,130 @@
+package maildir
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FS is a writable file system used to store a Maildir.
+//
+// Paths are slash- or OS-separated names as built by path/filepath. Errors
+// should wrap fs.ErrNotExist and fs.ErrExist where applicable, as the os
+// package does.
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Link(oldname, newname string) error
+	Remove(name string) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Mkdir(name string, perm fs.FileMode) error
+}
+
+// File is an open file in a FS.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+
+	// Name returns the name of the file as passed to Open or OpenFile.
+	Name() string
+	Stat() (fs.FileInfo, error)
+	// Sync commits the contents of the file to stable storage.
+	Sync() error
+	// ReadDir reads the contents of a directory, with the same semantics as
+	// os.File.ReadDir.
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// ChtimesFS is a FS which can change the access and modification times of
+// files.
+type ChtimesFS interface {
+	FS
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// OSFS is the FS of the operating system, as exposed by the os package.
+type OSFS struct{}
+
+var _ ChtimesFS = OSFS{}
+
+func (OSFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// isOSFS reports whether fsys is the OS file system. Features which are
+// tied to the OS, such as the key index or quotas, are only enabled there.
+func isOSFS(fsys FS) bool {
+	_, ok := fsys.(OSFS)
+	return ok
+}
+
+// syncFSDir flushes a directory of fsys to disk.
+func syncFSDir(fsys FS, name string) error {
+	if isOSFS(fsys) {
+		return syncDir(name)
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readDirNames reads the next names of a directory, with the same semantics
+// as os.File.Readdirnames.
+func readDirNames(f File, n int) ([]string, error) {
+	entries, err := f.ReadDir(n)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, err
+}
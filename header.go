@@ -0,0 +1,89 @@
+package maildir
+
+import (
+	"bufio"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Header reads and parses the header of the message.
+//
+// Only the header is read from the file, the body is left untouched. The
+// header is cached in the Message: subsequent calls don't access the file,
+// even if it has been modified since. Use ReadHeader to bypass the cache.
+func (msg *Message) Header() (mail.Header, error) {
+	if msg.header != nil {
+		return msg.header, nil
+	}
+
+	h, err := msg.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	msg.header = h
+	return msg.header, nil
+}
+
+// ReadHeader reads and parses the header of the message, like Header, but
+// always reads it from the file. The header cached by Header is left
+// untouched.
+func (msg *Message) ReadHeader() (mail.Header, error) {
+	f, err := msg.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := mail.ReadMessage(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	return m.Header, nil
+}
+
+// Date returns the parsed Date header field of the message.
+func (msg *Message) Date() (time.Time, error) {
+	h, err := msg.Header()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return h.Date()
+}
+
+// From returns the parsed From header field of the message.
+func (msg *Message) From() ([]*mail.Address, error) {
+	h, err := msg.Header()
+	if err != nil {
+		return nil, err
+	}
+	return h.AddressList("From")
+}
+
+// Subject returns the Subject header field of the message, with RFC 2047
+// encoded-words decoded. If decoding fails, the raw value is returned.
+func (msg *Message) Subject() (string, error) {
+	h, err := msg.Header()
+	if err != nil {
+		return "", err
+	}
+	subject := h.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	return subject, nil
+}
+
+// MessageID returns the Message-ID header field of the message, without angle
+// brackets.
+func (msg *Message) MessageID() (string, error) {
+	h, err := msg.Header()
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(h.Get("Message-Id"))
+	id = strings.TrimPrefix(id, "<")
+	id = strings.TrimSuffix(id, ">")
+	return id, nil
+}
@@ -0,0 +1,102 @@
+package maildir
+
+import (
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testHeaderMessage = "From: Alice <alice@example.org>\r\n" +
+	"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"Message-ID: <1234@example.org>\r\n" +
+	"\r\n" +
+	"Hello!\r\n"
+
+func TestMessage_Header(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	msg, w, err := d.Create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, testHeaderMessage); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if subject, err := msg.Subject(); err != nil || subject != "Grüße" {
+		t.Errorf("Subject() = %q, %v, want %q", subject, err, "Grüße")
+	}
+	if from, err := msg.From(); err != nil || len(from) != 1 || from[0].Address != "alice@example.org" {
+		t.Errorf("From() = %v, %v, want alice@example.org", from, err)
+	}
+	want := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	if date, err := msg.Date(); err != nil || !date.Equal(want) {
+		t.Errorf("Date() = %v, %v, want %v", date, err, want)
+	}
+	if id, err := msg.MessageID(); err != nil || id != "1234@example.org" {
+		t.Errorf("MessageID() = %q, %v, want 1234@example.org", id, err)
+	}
+
+	// The header is cached, unless read with ReadHeader
+	if err := os.WriteFile(msg.Filename(), []byte("Subject: changed\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := msg.ReadHeader(); err != nil || h.Get("Subject") != "changed" {
+		t.Errorf("ReadHeader() = %v, %v, want the changed header", h, err)
+	}
+	if err := os.Remove(msg.Filename()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.Header(); err != nil {
+		t.Errorf("Header() = %v, want cached header", err)
+	}
+	if subject, err := msg.Subject(); err != nil || subject != "Grüße" {
+		t.Errorf("Subject() = %q, %v, want the cached %q", subject, err, "Grüße")
+	}
+	if _, err := msg.ReadHeader(); err == nil {
+		t.Error("ReadHeader() on removed message succeeded")
+	}
+}
+
+func TestMessage_Header_fs(t *testing.T) {
+	t.Parallel()
+
+	d := FSDir{FS: NewMemFS(), Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	msg, w, err := d.Create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, testHeaderMessage); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := msg.Subject(); err != nil || subject != "Grüße" {
+		t.Errorf("Subject() on MemFS = %q, %v, want %q", subject, err, "Grüße")
+	}
+
+	r := NewReader(fstest.MapFS{
+		"cur/1700000000.M1.example" + string(separator) + "2,S": {Data: []byte(testHeaderMessage)},
+	}, ".")
+	msg, err = r.MessageByKey("1700000000.M1.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := msg.MessageID(); err != nil || id != "1234@example.org" {
+		t.Errorf("MessageID() on Reader = %q, %v, want 1234@example.org", id, err)
+	}
+}
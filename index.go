@@ -0,0 +1,321 @@
+package maildir
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// indexFilename is the name of the key index file of a Maildir.
+const indexFilename = "maildir-keyindex"
+
+// indexCompactThreshold is the minimum number of obsolete entries before
+// the index file is rewritten.
+const indexCompactThreshold = 1024
+
+// maxIndexes is the maximum number of key indexes kept in memory. The least
+// recently used ones are evicted first, and loaded again when needed.
+const maxIndexes = 64
+
+// keyIndex is the in-memory copy of a key index file. The file is an
+// append-only log: lines starting with "+" add or update a basename, lines
+// starting with "-" remove a key.
+type keyIndex struct {
+	mu   sync.Mutex
+	path string // the Maildir path
+	indexState
+}
+
+type indexState struct {
+	fi        os.FileInfo       // the loaded file
+	offset    int64             // number of bytes loaded
+	entries   int               // number of lines loaded
+	basenames map[string]string // by key
+}
+
+var (
+	indexesMu  sync.Mutex
+	indexes    = make(map[string]*list.Element) // by Maildir path
+	indexesLRU = list.New()                     // of *keyIndex, most recently used first
+)
+
+func (d Dir) keyIndex() *keyIndex {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	path := filepath.Clean(string(d))
+	if elem, ok := indexes[path]; ok {
+		indexesLRU.MoveToFront(elem)
+		return elem.Value.(*keyIndex)
+	}
+
+	idx := &keyIndex{path: path}
+	indexes[path] = indexesLRU.PushFront(idx)
+	if indexesLRU.Len() > maxIndexes {
+		oldest := indexesLRU.Remove(indexesLRU.Back()).(*keyIndex)
+		delete(indexes, oldest.path)
+	}
+	return idx
+}
+
+// forget evicts the index from memory. It is called when the index file
+// doesn't exist, so that Maildirs without an index don't use any memory.
+func (idx *keyIndex) forget() {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if elem, ok := indexes[idx.path]; ok && elem.Value == idx {
+		indexesLRU.Remove(elem)
+		delete(indexes, idx.path)
+	}
+}
+
+func (d Dir) indexPath() string {
+	return filepath.Join(string(d), indexFilename)
+}
+
+// EnableIndex creates a persistent index mapping message keys to filenames,
+// making MessageByKey fast on large mailboxes.
+//
+// Once enabled, the index is kept up to date by SetFlags, MoveTo, Remove,
+// Create and Unseen. Changes made by other programs make the index stale: it
+// is then repaired automatically by rescanning cur.
+func (d Dir) EnableIndex() error {
+	idx := d.keyIndex()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return d.rebuildIndex(idx)
+}
+
+// DisableIndex removes the key index, if any.
+func (d Dir) DisableIndex() error {
+	idx := d.keyIndex()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.indexState = indexState{}
+	idx.forget()
+	err := os.Remove(d.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
+
+// rebuildIndex scans cur and atomically replaces the index file.
+func (d Dir) rebuildIndex(idx *keyIndex) error {
+	f, err := os.Open(filepath.Join(string(d), "cur"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	basenames := make(map[string]string)
+	for {
+		names, err := f.Readdirnames(readdirChunk)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if n[0] == '.' {
+				continue
+			}
+			key, _, _ := strings.Cut(n, string(separator))
+			basenames[key] = n
+			buf.WriteString("+" + n + "\n")
+		}
+	}
+
+	tmpKey, err := newKey()
+	if err != nil {
+		return err
+	}
+	tmpFilename := filepath.Join(string(d), "tmp", tmpKey)
+	if err := os.WriteFile(tmpFilename, buf.Bytes(), 0600); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	if err := os.Rename(tmpFilename, d.indexPath()); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	fi, err := os.Stat(d.indexPath())
+	if err != nil {
+		return err
+	}
+	idx.indexState = indexState{
+		fi:        fi,
+		offset:    int64(buf.Len()),
+		entries:   len(basenames),
+		basenames: basenames,
+	}
+	return nil
+}
+
+// loadIndex reads the entries appended to the index file since the last
+// call. It returns false if the index is disabled.
+func (d Dir) loadIndex(idx *keyIndex) (bool, error) {
+	f, err := os.Open(d.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		idx.indexState = indexState{}
+		idx.forget()
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if idx.fi == nil || !os.SameFile(idx.fi, fi) || fi.Size() < idx.offset {
+		// The file has been replaced, reload it entirely
+		idx.indexState = indexState{basenames: make(map[string]string)}
+	}
+	idx.fi = fi
+	if fi.Size() == idx.offset {
+		return true, nil
+	}
+
+	if _, err := f.Seek(idx.offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// Ignore incomplete lines, they'll be read next time
+			break
+		} else if err != nil {
+			return false, err
+		}
+		idx.offset += int64(len(line))
+		idx.entries++
+
+		line = strings.TrimSuffix(line, "\n")
+		if len(line) < 2 {
+			continue
+		}
+		switch line[0] {
+		case '+':
+			key, _, _ := strings.Cut(line[1:], string(separator))
+			idx.basenames[key] = line[1:]
+		case '-':
+			delete(idx.basenames, line[1:])
+		}
+	}
+
+	return true, nil
+}
+
+// indexFilename looks up a key in the index. It returns false if the index
+// is disabled. If the key isn't in the index or its file doesn't exist, an
+// empty filename is returned: the caller needs to search for the key and
+// call repairIndex if it is found.
+func (d Dir) indexFilename(key string) (filename string, enabled bool, err error) {
+	idx := d.keyIndex()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if enabled, err := d.loadIndex(idx); !enabled || err != nil {
+		return "", enabled, err
+	}
+
+	if basename, ok := idx.basenames[key]; ok {
+		filename := filepath.Join(string(d), "cur", basename)
+		if _, err := os.Stat(filename); err == nil {
+			if idx.entries-len(idx.basenames) > indexCompactThreshold && idx.entries > 2*len(idx.basenames) {
+				// Failing to compact the index isn't fatal
+				d.rebuildIndex(idx)
+			}
+			return filename, true, nil
+		}
+	}
+
+	// The index is stale or the key doesn't exist
+	return "", true, nil
+}
+
+// repairIndex rebuilds the index, if enabled. It is called when a lookup
+// found a key missing from the index.
+func (d Dir) repairIndex() error {
+	idx := d.keyIndex()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if enabled, err := d.loadIndex(idx); !enabled || err != nil {
+		return err
+	}
+	return d.rebuildIndex(idx)
+}
+
+// indexEntry is a change to record in the index: if basename is empty, the
+// key is removed.
+type indexEntry struct {
+	key      string
+	basename string
+}
+
+// updateIndex appends entries to the index of the Maildir, if enabled.
+func (d Dir) updateIndex(entries ...indexEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(d.indexPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if entry.basename != "" {
+			buf.WriteString("+" + entry.basename + "\n")
+		} else {
+			buf.WriteString("-" + entry.key + "\n")
+		}
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// indexedDir returns the Maildir of a message file in cur, which may have a
+// key index.
+func indexedDir(filename string) (Dir, bool) {
+	dir := filepath.Dir(filename)
+	if filepath.Base(dir) != "cur" {
+		return "", false
+	}
+	return Dir(filepath.Dir(dir)), true
+}
+
+// updateMessageIndex records the current filename of a message in the index
+// of its Maildir, or its removal if removed is true. Index errors are
+// ignored: a stale index is repaired on lookup.
+func updateMessageIndex(filename, key string, removed bool) {
+	d, ok := indexedDir(filename)
+	if !ok {
+		return
+	}
+	entry := indexEntry{key: key}
+	if !removed {
+		entry.basename = filepath.Base(filename)
+	}
+	d.updateIndex(entry)
+}
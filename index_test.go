@@ -0,0 +1,130 @@
+package maildir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "before index")
+	if _, err := d.Unseen(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.EnableIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	makeDelivery(t, d, "after index")
+	makeDelivery(t, d, "after index")
+	msgs, err := d.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseen, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs = append(msgs, unseen...)
+	if len(msgs) != 3 {
+		t.Fatalf("got %v messages, want 3", len(msgs))
+	}
+
+	if err := msgs[0].SetFlags([]Flag{FlagSeen, FlagFlagged, FlagDraft}); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgs[1].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	created, w, err := d.Create([]Flag{FlagPassed, FlagReplied, FlagTrashed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename a file behind the index's back
+	stale := filepath.Join(string(d), "cur", msgs[2].Key()+string(separator)+"2,DRT")
+	if err := os.Rename(msgs[2].Filename(), stale); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{msgs[0].Filename(), created.Filename(), stale} {
+		_, basename := filepath.Split(want)
+		key, _, _ := parseBasename(basename)
+		msg, err := d.MessageByKey(key)
+		if err != nil {
+			t.Errorf("MessageByKey(%q) = %v", key, err)
+		} else if msg.Filename() != want {
+			t.Errorf("MessageByKey(%q) = %q, want %q", key, msg.Filename(), want)
+		}
+	}
+
+	var keyErr *KeyError
+	if _, err := d.MessageByKey(msgs[1].Key()); !errors.As(err, &keyErr) {
+		t.Errorf("MessageByKey() on removed message = %v, want *KeyError", err)
+	}
+
+	// A missing key doesn't cause the index to be rebuilt
+	before, err := os.Stat(d.indexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MessageByKey("nope"); !errors.As(err, &keyErr) {
+		t.Errorf("MessageByKey() on missing key = %v, want *KeyError", err)
+	}
+	if after, err := os.Stat(d.indexPath()); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(before, after) {
+		t.Error("index rebuilt on lookup of a missing key")
+	}
+
+	if err := d.DisableIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MessageByKey(msgs[0].Key()); err != nil {
+		t.Errorf("MessageByKey() without index = %v", err)
+	}
+
+	// Maildirs without an index are not kept in memory
+	indexesMu.Lock()
+	_, cached := indexes[filepath.Clean(string(d))]
+	n := indexesLRU.Len()
+	indexesMu.Unlock()
+	if cached {
+		t.Error("index kept in memory after DisableIndex()")
+	}
+	if n > maxIndexes {
+		t.Errorf("%v indexes in memory, want at most %v", n, maxIndexes)
+	}
+}
+
+func TestIndex_evict(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	for i := 0; i <= maxIndexes; i++ {
+		Dir(filepath.Join(base, strconv.Itoa(i))).keyIndex()
+	}
+
+	indexesMu.Lock()
+	_, first := indexes[filepath.Join(base, "0")]
+	_, last := indexes[filepath.Join(base, strconv.Itoa(maxIndexes))]
+	indexesMu.Unlock()
+	if first {
+		t.Error("least recently used index not evicted")
+	}
+	if !last {
+		t.Error("most recently used index evicted")
+	}
+}
@@ -0,0 +1,46 @@
+// Package dotlock implements lock files compatible with Dovecot's dotlocks.
+package dotlock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	// Timeout is the maximum amount of time to wait for a lock.
+	Timeout = 2 * time.Minute
+	// StaleTimeout is the age after which an unmodified lock file is
+	// considered stale and overridden, as done by Dovecot.
+	StaleTimeout = 2 * time.Minute
+	// RetryInterval is the delay between two locking attempts.
+	RetryInterval = 100 * time.Millisecond
+)
+
+// Create creates the lock file at path, waiting for it to be released if
+// another process holds it.
+//
+// The lock is released by removing the file, or by renaming it over the
+// locked file once new contents have been written to it.
+func Create(path string) (*os.File, error) {
+	deadline := time.Now().Add(Timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			return f, nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > StaleTimeout {
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("maildir: timed out waiting for lock %q", path)
+		}
+		time.Sleep(RetryInterval)
+	}
+}
@@ -0,0 +1,34 @@
+package dotlock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	Timeout = 50 * time.Millisecond
+	RetryInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "file.lock")
+	f, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := Create(path); err == nil {
+		t.Fatal("Create() succeeded on a held lock")
+	}
+
+	old := time.Now().Add(-2 * StaleTimeout)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	f2, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() on a stale lock = %v", err)
+	}
+	f2.Close()
+}
@@ -0,0 +1,96 @@
+//go:build go1.23
+
+package maildir
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// All returns an iterator over all messages, first those in new and then
+// those in cur. See New and Cur.
+func (d Dir) All() iter.Seq2[*Message, error] {
+	return d.fsDir().All()
+}
+
+// All is like Dir.All.
+func (d FSDir) All() iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for msg, err := range d.New() {
+			if !yield(msg, err) {
+				return
+			}
+		}
+		for msg, err := range d.Cur() {
+			if !yield(msg, err) {
+				return
+			}
+		}
+	}
+}
+
+// New returns an iterator over the messages in new. Unlike Unseen, the
+// messages are left in new. Their key is the file name without any info
+// section, and they have no flags.
+//
+// The directory is read lazily, in chunks. If reading it fails, the error is
+// yielded and the iteration stops.
+func (d Dir) New() iter.Seq2[*Message, error] {
+	return d.fsDir().New()
+}
+
+// New is like Dir.New.
+func (d FSDir) New() iter.Seq2[*Message, error] {
+	return d.iterate("new", func(basename string) (*Message, error) {
+		return d.newUnseenMessage(basename), nil
+	})
+}
+
+// Cur returns an iterator over the messages in cur.
+//
+// The directory is read lazily, in chunks. Malformed entries are yielded as
+// errors and the iteration continues, as with Walk. If reading the directory
+// fails, the error is yielded and the iteration stops.
+func (d Dir) Cur() iter.Seq2[*Message, error] {
+	return d.fsDir().Cur()
+}
+
+// Cur is like Dir.Cur.
+func (d FSDir) Cur() iter.Seq2[*Message, error] {
+	return d.iterate("cur", func(basename string) (*Message, error) {
+		return d.newMessage(d.join("cur"), basename)
+	})
+}
+
+// iterate returns an iterator over the files in the sub-directory sub. The
+// directory is closed when the iteration stops.
+func (d FSDir) iterate(sub string, newMessage func(basename string) (*Message, error)) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		f, err := d.fsys().Open(d.join(sub))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+
+		for {
+			names, err := readDirNames(f, readdirChunk)
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, n := range names {
+				if n[0] == '.' {
+					continue
+				}
+				if !yield(newMessage(n)) {
+					return
+				}
+			}
+		}
+	}
+}
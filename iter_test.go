@@ -0,0 +1,77 @@
+//go:build go1.23
+
+package maildir
+
+import (
+	"testing"
+)
+
+func TestDir_All(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		makeDelivery(t, d, "this is a message")
+	}
+	if _, err := d.Unseen(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "this is a new message")
+
+	var filenames []string
+	for msg, err := range d.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filenames) == 0 && len(msg.Flags()) != 0 {
+			t.Errorf("Flags() of new message = %v, want none", msg.Flags())
+		}
+		filenames = append(filenames, msg.Filename())
+	}
+	if len(filenames) != 4 {
+		t.Errorf("All() yielded %v messages, want 4", len(filenames))
+	}
+
+	n := 0
+	for _, err := range d.Cur() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("Cur() yielded %v messages after break, want 1", n)
+	}
+
+	n = 0
+	for _, err := range d.New() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("New() yielded %v messages, want 1", n)
+	}
+	if count, err := d.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("UnseenCount() = %v after New(), want 1", count)
+	}
+}
+
+func TestDir_Cur_error(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	for msg, err := range d.Cur() {
+		if err == nil {
+			t.Errorf("Cur() yielded %v on missing directory, want an error", msg)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package maildir
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A KeyGenerator generates unique keys for new messages.
+type KeyGenerator interface {
+	// NewKey returns a new unique key for a message. fi describes the file
+	// the message is being written to in tmp.
+	NewKey(fi os.FileInfo) (string, error)
+}
+
+// ClassicKeyGenerator generates keys with the classic format of the Maildir
+// specification: "<seconds>.<pid><counter><random>.<host>".
+//
+// This is the default key generator.
+type ClassicKeyGenerator struct{}
+
+// NewKey implements KeyGenerator.
+func (ClassicKeyGenerator) NewKey(fi os.FileInfo) (string, error) {
+	return newKey()
+}
+
+// modernSeq is the number of keys generated by ModernKeyGenerator in this
+// process.
+var modernSeq int64
+
+// ModernKeyGenerator generates keys with the modern format of the Maildir
+// specification, as used by qmail and Courier:
+// "<seconds>.M<usec>P<pid>V<dev>I<ino>Q<seq>R<random>.<host>".
+//
+// The device and inode numbers of the file in tmp are omitted on systems
+// which don't provide them.
+type ModernKeyGenerator struct{}
+
+// NewKey implements KeyGenerator.
+func (ModernKeyGenerator) NewKey(fi os.FileInfo) (string, error) {
+	host, err := escapedHostname()
+	if err != nil {
+		return "", err
+	}
+
+	var random [8]byte
+	if _, err := io.ReadFull(rand.Reader, random[:]); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d.M%dP%d", now.Unix(), now.Nanosecond()/1000, os.Getpid())
+	if fi != nil {
+		if dev, ino, ok := fileDevIno(fi); ok {
+			fmt.Fprintf(&sb, "V%xI%x", dev, ino)
+		}
+	}
+	fmt.Fprintf(&sb, "Q%dR%x.%s", atomic.AddInt64(&modernSeq, 1), random, host)
+	return sb.String(), nil
+}
+
+// DeterministicKeyGenerator generates predictable keys, for use in tests:
+// "<seconds>.M<usec>Q<seq>.<host>", where seq starts at 1 and is incremented
+// for each key.
+//
+// Keys are only unique among the ones generated by the same
+// DeterministicKeyGenerator.
+type DeterministicKeyGenerator struct {
+	Time time.Time // the delivery time, the Unix epoch if zero
+	Host string    // the host name, "localhost" if empty
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// NewKey implements KeyGenerator.
+func (gen *DeterministicKeyGenerator) NewKey(fi os.FileInfo) (string, error) {
+	gen.mu.Lock()
+	gen.seq++
+	seq := gen.seq
+	gen.mu.Unlock()
+
+	t := gen.Time
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	host := gen.Host
+	if host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%d.M%dQ%d.%s", t.Unix(), t.Nanosecond()/1000, seq, escapeHost(host)), nil
+}
+
+func escapeHost(host string) string {
+	host = strings.Replace(host, "/", `\057`, -1)
+	host = strings.Replace(host, string(separator), `\072`, -1)
+	return host
+}
+
+func escapedHostname() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return escapeHost(host), nil
+}
+
+// Key is a parsed message key.
+//
+// Keys have the form "<seconds>.<unique>.<host>", optionally followed by
+// comma-separated attributes such as ",S=<size>". The formats written by
+// this package and by common delivery agents (Postfix, Dovecot, Courier,
+// getmail, etc) are understood.
+type Key struct {
+	Seconds      int64  // delivery time, in seconds since the Unix epoch
+	Microseconds int    // microseconds part of the delivery time, or -1 if unknown
+	Unique       string // delivery identifier
+	Host         string // host name, unescaped
+	Size         int64  // S= attribute, or -1 if absent
+	VirtualSize  int64  // W= attribute, or -1 if absent
+}
+
+// ParseKey parses a message key. An info section, if any, is ignored.
+func ParseKey(s string) (*Key, error) {
+	key, _, _ := strings.Cut(s, string(separator))
+
+	secs, rest, ok := strings.Cut(key, ".")
+	if !ok || secs == "" {
+		return nil, &MailfileError{s}
+	}
+	seconds, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return nil, &MailfileError{s}
+	}
+
+	unique, host, ok := strings.Cut(rest, ".")
+	if !ok || unique == "" {
+		return nil, &MailfileError{s}
+	}
+	host, _, _ = strings.Cut(host, ",")
+	host = strings.Replace(host, `\057`, "/", -1)
+	host = strings.Replace(host, `\072`, ":", -1)
+
+	k := &Key{
+		Seconds:      seconds,
+		Microseconds: parseMicroseconds(unique),
+		Unique:       unique,
+		Host:         host,
+		Size:         -1,
+		VirtualSize:  -1,
+	}
+	if size, ok := keyAttr(key, 'S'); ok {
+		k.Size = size
+	}
+	if size, ok := keyAttr(key, 'W'); ok {
+		k.VirtualSize = size
+	}
+	return k, nil
+}
+
+// parseMicroseconds extracts the M<usec> field of a modern delivery
+// identifier, e.g. "M123456P789" or "V801I2a3bM123456". It returns -1 if
+// there is none.
+func parseMicroseconds(unique string) int {
+	for i := 0; i < len(unique); i++ {
+		if unique[i] != 'M' {
+			continue
+		}
+		// Fields are made of an uppercase letter followed by a value
+		if i > 0 && !isKeyFieldValue(unique[i-1]) {
+			continue
+		}
+		j := i + 1
+		for j < len(unique) && unique[j] >= '0' && unique[j] <= '9' {
+			j++
+		}
+		if j == i+1 || j-i-1 > 6 {
+			continue
+		}
+		usec, err := strconv.Atoi(unique[i+1 : j])
+		if err != nil {
+			continue
+		}
+		return usec
+	}
+	return -1
+}
+
+func isKeyFieldValue(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')
+}
+
+// Time returns the delivery time.
+func (k *Key) Time() time.Time {
+	usec := k.Microseconds
+	if usec < 0 {
+		usec = 0
+	}
+	return time.Unix(k.Seconds, int64(usec)*1000)
+}
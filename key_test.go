@@ -0,0 +1,145 @@
+package maildir
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestModernKeyGenerator(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ModernKeyGenerator{}.NewKey(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := `^[0-9]+\.M[0-9]+P[0-9]+V[0-9a-f]+I[0-9a-f]+Q[0-9]+R[0-9a-f]+\.`
+	if runtime.GOOS == "windows" {
+		re = `^[0-9]+\.M[0-9]+P[0-9]+Q[0-9]+R[0-9a-f]+\.`
+	}
+	if !regexp.MustCompile(re).MatchString(key) {
+		t.Errorf("NewKey() = %q, want match for %q", key, re)
+	}
+}
+
+func TestDeterministicKeyGenerator(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	gen := &DeterministicKeyGenerator{
+		Time: time.Unix(1700000000, 123456000),
+		Host: "mx/1",
+	}
+	options := &DeliveryOptions{KeyGenerator: gen}
+
+	msg, w, err := d.CreateWithOptions([]Flag{FlagSeen}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := `1700000000.M123456Q1.mx\0571`; msg.Key() != want {
+		t.Errorf("Key() = %q, want %q", msg.Key(), want)
+	}
+
+	del, err := NewDeliveryWithOptions(string(d), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `1700000000.M123456Q2.mx\0571`; len(msgs) != 1 || msgs[0].Key() != want {
+		t.Errorf("Unseen() = %v, want key %q", msgs, want)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		s    string
+		want Key
+	}{
+		{
+			// newKey
+			s:    "1700000000.12345100011a2b3c4d5e6f708192.mail.example.org",
+			want: Key{1700000000, -1, "12345100011a2b3c4d5e6f708192", "mail.example.org", -1, -1},
+		},
+		{
+			// Dovecot
+			s:    "1276528487.M364837P9451.kurkku,S=1355,W=1394" + string(separator) + "2,S",
+			want: Key{1276528487, 364837, "M364837P9451", "kurkku", 1355, 1394},
+		},
+		{
+			// Postfix
+			s:    "1700000000.V801I2a3bM123456.mx",
+			want: Key{1700000000, 123456, "V801I2a3bM123456", "mx", -1, -1},
+		},
+		{
+			// getmail
+			s:    "1700000000.M5P789Q1R4f2a.host",
+			want: Key{1700000000, 5, "M5P789Q1R4f2a", "host", -1, -1},
+		},
+		{
+			// escaped host name
+			s:    `1700000000.M1P2.a\057b\072c,S=5`,
+			want: Key{1700000000, 1, "M1P2", "a/b:c", 5, -1},
+		},
+	}
+	for _, tc := range tests {
+		k, err := ParseKey(tc.s)
+		if err != nil {
+			t.Errorf("ParseKey(%q) = %v", tc.s, err)
+		} else if *k != tc.want {
+			t.Errorf("ParseKey(%q) = %+v, want %+v", tc.s, *k, tc.want)
+		}
+	}
+
+	k, err := ParseKey("1276528487.M364837P9451.kurkku")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1276528487, 364837000); !k.Time().Equal(want) {
+		t.Errorf("Time() = %v, want %v", k.Time(), want)
+	}
+
+	key, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseKey(key); err != nil {
+		t.Errorf("ParseKey(%q) = %v", key, err)
+	}
+
+	for _, s := range []string{"", "foo", "foo.bar.baz", "1700000000..host"} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("ParseKey(%q) = nil, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package maildir
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-maildir/internal/dotlock"
+)
+
+// keywordsFilename is the name of the file mapping keyword letters to names,
+// as used by Dovecot.
+const keywordsFilename = "dovecot-keywords"
+
+// maxKeywords is the number of keyword letters available (a to z).
+const maxKeywords = 26
+
+// A KeywordError occurs when a keyword cannot be stored in a Maildir.
+type KeywordError struct {
+	Keyword string // the keyword
+	Full    bool   // no letter is left in the keyword table
+}
+
+func (e *KeywordError) Error() string {
+	if e.Full {
+		return fmt.Sprintf("maildir: no letter left for keyword %q", e.Keyword)
+	}
+	return fmt.Sprintf("maildir: invalid keyword %q", e.Keyword)
+}
+
+// KeywordTable maps IMAP keywords (e.g. "$Junk") to the lowercase letters
+// stored in the info section of message filenames.
+//
+// The table is backed by the dovecot-keywords file of the Maildir. Keywords
+// are compared case-insensitively.
+type KeywordTable struct {
+	dir   Dir
+	names [maxKeywords]string
+}
+
+// KeywordTable reads the keyword table of the Maildir. If the Maildir has no
+// keyword table yet, an empty table is returned.
+func (d Dir) KeywordTable() (*KeywordTable, error) {
+	kw := &KeywordTable{dir: d}
+	if err := kw.load(); err != nil {
+		return nil, err
+	}
+	return kw, nil
+}
+
+func (kw *KeywordTable) filename() string {
+	return filepath.Join(string(kw.dir), keywordsFilename)
+}
+
+func (kw *KeywordTable) load() error {
+	f, err := os.Open(kw.filename())
+	if errors.Is(err, os.ErrNotExist) {
+		kw.names = [maxKeywords]string{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var names [maxKeywords]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		idx, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 0 || i >= maxKeywords || !validKeyword(name) {
+			// Ignore invalid lines, like Dovecot does
+			continue
+		}
+		names[i] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	kw.names = names
+	return nil
+}
+
+func (kw *KeywordTable) writeTo(w io.Writer) error {
+	for i, name := range kw.names {
+		if name == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%d %s\n", i, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validKeyword(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flag returns the letter assigned to a keyword.
+func (kw *KeywordTable) Flag(name string) (Flag, bool) {
+	for i, n := range kw.names {
+		if n != "" && strings.EqualFold(n, name) {
+			return Flag('a' + i), true
+		}
+	}
+	return 0, false
+}
+
+// Name returns the keyword assigned to a letter.
+func (kw *KeywordTable) Name(f Flag) (string, bool) {
+	if f < 'a' || f > 'z' || kw.names[f-'a'] == "" {
+		return "", false
+	}
+	return kw.names[f-'a'], true
+}
+
+// Names returns all keywords in the table, sorted by letter.
+func (kw *KeywordTable) Names() []string {
+	var names []string
+	for _, name := range kw.names {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Add returns the letter assigned to a keyword, assigning a new one if the
+// keyword isn't in the table yet.
+//
+// New letters are saved to the dovecot-keywords file while holding its lock,
+// so that concurrent updates from other processes are not lost.
+func (kw *KeywordTable) Add(name string) (Flag, error) {
+	if !validKeyword(name) {
+		return 0, &KeywordError{Keyword: name}
+	}
+	if f, ok := kw.Flag(name); ok {
+		return f, nil
+	}
+
+	lock, err := dotlock.Create(kw.filename() + ".lock")
+	if err != nil {
+		return 0, err
+	}
+	published := false
+	defer func() {
+		if !published {
+			lock.Close()
+			os.Remove(lock.Name())
+		}
+	}()
+
+	// Another process might have updated the table
+	if err := kw.load(); err != nil {
+		return 0, err
+	}
+	if f, ok := kw.Flag(name); ok {
+		return f, nil
+	}
+
+	i := 0
+	for i < maxKeywords && kw.names[i] != "" {
+		i++
+	}
+	if i == maxKeywords {
+		return 0, &KeywordError{Keyword: name, Full: true}
+	}
+	kw.names[i] = name
+
+	if err := kw.writeTo(lock); err != nil {
+		return 0, err
+	}
+	if err := lock.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(lock.Name(), kw.filename()); err != nil {
+		return 0, err
+	}
+	published = true
+
+	return Flag('a' + i), nil
+}
+
+// targetFlags returns the flags of the message once moved or copied to
+// target. Each Maildir has its own keyword table, so keyword letters are
+// translated to the letters of the target table, adding keywords missing
+// from it. Letters which aren't in the table of the message's Maildir are
+// dropped. changed is false if the flags are left as is.
+func (msg *Message) targetFlags(target Dir) (flags []Flag, changed bool, err error) {
+	src := msg.dir()
+	if !isOSFS(msg.fsys()) || filepath.Clean(string(src)) == filepath.Clean(string(target)) {
+		return msg.flags, false, nil
+	}
+	hasKeywords := false
+	for _, f := range msg.flags {
+		if f >= 'a' && f <= 'z' {
+			hasKeywords = true
+		}
+	}
+	if !hasKeywords {
+		return msg.flags, false, nil
+	}
+
+	srcTable, err := src.KeywordTable()
+	if err != nil {
+		return nil, false, err
+	}
+	targetTable, err := target.KeywordTable()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, f := range msg.flags {
+		if f >= 'a' && f <= 'z' {
+			name, ok := srcTable.Name(f)
+			if !ok {
+				continue
+			}
+			if f, err = targetTable.Add(name); err != nil {
+				return nil, false, err
+			}
+		}
+		flags = append(flags, f)
+	}
+	return flags, true, nil
+}
+
+// Keywords returns the keywords of the message, as named by the keyword
+// table. Letters missing from the table are ignored.
+func (msg *Message) Keywords(kw *KeywordTable) []string {
+	var names []string
+	for _, f := range msg.flags {
+		if name, ok := kw.Name(f); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// AddKeywords adds keywords to the message, assigning new letters in the
+// keyword table if necessary.
+func (msg *Message) AddKeywords(kw *KeywordTable, names ...string) error {
+	flags := append([]Flag(nil), msg.flags...)
+	for _, name := range names {
+		f, err := kw.Add(name)
+		if err != nil {
+			return err
+		}
+		flags = append(flags, f)
+	}
+	return msg.SetFlags(flags)
+}
+
+// RemoveKeywords removes keywords from the message. Keywords missing from the
+// keyword table are ignored.
+func (msg *Message) RemoveKeywords(kw *KeywordTable, names ...string) error {
+	remove := make(map[Flag]bool)
+	for _, name := range names {
+		if f, ok := kw.Flag(name); ok {
+			remove[f] = true
+		}
+	}
+
+	var flags []Flag
+	for _, f := range msg.flags {
+		if !remove[f] {
+			flags = append(flags, f)
+		}
+	}
+	return msg.SetFlags(flags)
+}
@@ -0,0 +1,128 @@
+package maildir
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "foo")
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := msgs[0]
+
+	kw, err := d.KeywordTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.SetFlags([]Flag{FlagSeen}); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AddKeywords(kw, "$Junk", "$Forwarded"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Flag{FlagSeen, 'a', 'b'}
+	if !reflect.DeepEqual(msg.Flags(), want) {
+		t.Errorf("Flags() = %q, want %q", msg.Flags(), want)
+	}
+
+	// Re-read the table from disk
+	kw, err = d.KeywordTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := kw.Names(); !reflect.DeepEqual(names, []string{"$Junk", "$Forwarded"}) {
+		t.Errorf("Names() = %q", names)
+	}
+	if f, ok := kw.Flag("$junk"); !ok || f != 'a' {
+		t.Errorf("Flag($junk) = %q, %v, want 'a'", f, ok)
+	}
+
+	msg, err = d.MessageByKey(msg.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.RemoveKeywords(kw, "$Junk"); err != nil {
+		t.Fatal(err)
+	}
+	if names := msg.Keywords(kw); !reflect.DeepEqual(names, []string{"$Forwarded"}) {
+		t.Errorf("Keywords() = %q, want [$Forwarded]", names)
+	}
+
+	var kwErr *KeywordError
+	if _, err := kw.Add("bad keyword"); !errors.As(err, &kwErr) {
+		t.Errorf("Add() = %v, want *KeywordError", err)
+	}
+}
+
+func TestKeywords_moveCopy(t *testing.T) {
+	t.Parallel()
+
+	src := Dir(t.TempDir())
+	target := Dir(t.TempDir())
+	for _, d := range []Dir{src, target} {
+		if err := d.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	targetTable, err := target.KeywordTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := targetTable.Add("$Forwarded"); err != nil {
+		t.Fatal(err)
+	}
+
+	srcTable, err := src.KeywordTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*Message
+	for i := 0; i < 2; i++ {
+		msg, w, err := src.Create([]Flag{FlagSeen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.AddKeywords(srcTable, "$Junk"); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	copied, err := msgs[0].CopyTo(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msgs[1].MoveTo(target); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := targetTable.load(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Flag{FlagSeen, 'b'}
+	for _, msg := range []*Message{copied, msgs[1]} {
+		if !reflect.DeepEqual(msg.Flags(), want) {
+			t.Errorf("Flags() = %q, want %q", msg.Flags(), want)
+		}
+		if names := msg.Keywords(targetTable); !reflect.DeepEqual(names, []string{"$Junk"}) {
+			t.Errorf("Keywords() = %q, want [$Junk]", names)
+		}
+	}
+	if names := msgs[0].Keywords(srcTable); !reflect.DeepEqual(names, []string{"$Junk"}) {
+		t.Errorf("Keywords() of the original = %q, want [$Junk]", names)
+	}
+}
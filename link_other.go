@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package maildir
+
+// isLinkUnsupported reports whether a link error indicates that the file
+// system doesn't support hard links.
+func isLinkUnsupported(err error) bool {
+	return false
+}
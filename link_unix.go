@@ -0,0 +1,19 @@
+//go:build unix
+
+package maildir
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLinkUnsupported reports whether a link error indicates that the file
+// system doesn't support hard links.
+func isLinkUnsupported(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.ENOTSUP, syscall.EOPNOTSUPP, syscall.ENOSYS, syscall.EXDEV} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package maildir
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLinkUnsupported reports whether a link error indicates that the file
+// system doesn't support hard links.
+func isLinkUnsupported(err error) bool {
+	const (
+		errorInvalidFunction syscall.Errno = 1
+		errorNotSupported    syscall.Errno = 50
+	)
+	return errors.Is(err, errorInvalidFunction) || errors.Is(err, errorNotSupported)
+}
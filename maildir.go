@@ -7,13 +7,17 @@
 package maildir
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -58,6 +62,9 @@ func (e *MailfileError) Error() string {
 }
 
 // Flag is a message flag.
+//
+// Lowercase letters from a to z are keywords, their meaning is defined by the
+// Maildir's KeywordTable.
 type Flag rune
 
 const (
@@ -120,9 +127,26 @@ func (s flagList) Less(i, j int) bool { return s[i] < s[j] }
 
 // Message represents a message in a Maildir.
 type Message struct {
+	fs       FS // nil for OSFS
 	filename string
 	key      string
 	flags    []Flag
+	header   mail.Header // cached by Header
+}
+
+func (msg *Message) fsys() FS {
+	if msg.fs == nil {
+		return OSFS{}
+	}
+	return msg.fs
+}
+
+// updateIndex records the current filename of the message in the key index
+// of its Maildir, if any.
+func (msg *Message) updateIndex(removed bool) {
+	if isOSFS(msg.fsys()) {
+		updateMessageIndex(msg.filename, msg.key, removed)
+	}
 }
 
 // Filename returns the filesystem path to the message's file.
@@ -137,11 +161,94 @@ func (msg *Message) Key() string {
 	return msg.key
 }
 
+// Subdir returns the name of the Maildir sub-directory containing the
+// message, "new" or "cur".
+func (msg *Message) Subdir() string {
+	return filepath.Base(filepath.Dir(msg.filename))
+}
+
 // Flags returns the message flags.
 func (msg *Message) Flags() []Flag {
 	return msg.flags
 }
 
+// Size returns the size of the message file in bytes.
+//
+// The S= attribute of the key is used if present, otherwise the file is
+// stat'ed.
+func (msg *Message) Size() (int64, error) {
+	if size, ok := keyAttr(msg.key, 'S'); ok {
+		return size, nil
+	}
+	fi, err := msg.fsys().Stat(msg.filename)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// VirtualSize returns the RFC 822 size of the message, that is its size with
+// CRLF line endings.
+//
+// The W= attribute of the key is used if present, otherwise the file is read.
+func (msg *Message) VirtualSize() (int64, error) {
+	if size, ok := keyAttr(msg.key, 'W'); ok {
+		return size, nil
+	}
+	f, err := msg.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var c sizeCounter
+	if _, err := io.Copy(&c, f); err != nil {
+		return 0, err
+	}
+	return c.vsize, nil
+}
+
+// keyAttr returns the value of a size attribute (e.g. ",S=1234") in a key or
+// a basename.
+func keyAttr(key string, name byte) (int64, bool) {
+	key, _, _ = strings.Cut(key, string(separator))
+	attrs := strings.Split(key, ",")
+	for _, attr := range attrs[1:] {
+		if len(attr) < 3 || attr[0] != name || attr[1] != '=' {
+			continue
+		}
+		v, err := strconv.ParseInt(attr[2:], 10, 64)
+		if err != nil || v < 0 {
+			return 0, false
+		}
+		return v, true
+	}
+	return 0, false
+}
+
+// sizeCounter counts the size and the RFC 822 size of written data.
+type sizeCounter struct {
+	size  int64 // number of bytes
+	vsize int64 // number of bytes with CRLF line endings
+	cr    bool  // last byte was CR
+}
+
+func (c *sizeCounter) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	c.vsize += int64(len(p))
+	for _, b := range p {
+		if b == '\n' && !c.cr {
+			c.vsize++
+		}
+		c.cr = b == '\r'
+	}
+	return len(p), nil
+}
+
+// sizeAttrs returns the S= and W= attributes to append to a key.
+func (c *sizeCounter) sizeAttrs() string {
+	return fmt.Sprintf(",S=%d,W=%d", c.size, c.vsize)
+}
+
 // SetFlags sets the message flags.
 //
 // Any duplicate flags are dropped, and flags are sorted before being saved.
@@ -153,40 +260,98 @@ func (msg *Message) SetFlags(flags []Flag) error {
 	}
 
 	newFilename := filepath.Join(filepath.Dir(msg.filename), newBasename)
-	if err := os.Rename(msg.filename, newFilename); err != nil {
+	if err := msg.fsys().Rename(msg.filename, newFilename); err != nil {
 		return err
 	}
 	msg.filename = newFilename
 	msg.flags = flags
+	msg.updateIndex(false)
 	return nil
 }
 
 // Open reads the contents of a message.
 func (msg *Message) Open() (io.ReadCloser, error) {
-	return os.Open(msg.filename)
+	return msg.fsys().Open(msg.filename)
 }
 
 // Remove deletes a message.
+//
+// If the Maildir has a Maildir++ quota, the removal is recorded in it.
 func (msg *Message) Remove() error {
-	return os.Remove(msg.filename)
+	// The size is needed to update the quota once the file is gone
+	size, sizeErr := msg.Size()
+	if err := msg.fsys().Remove(msg.filename); err != nil {
+		return err
+	}
+	msg.updateIndex(true)
+	if isOSFS(msg.fsys()) && sizeErr == nil {
+		// The message has been removed, a failure to update the quota file
+		// is fixed by the next recalculation
+		msg.dir().addQuotaUsage(-size, -1)
+	}
+	return nil
+}
+
+// dir returns the Maildir containing the message.
+func (msg *Message) dir() Dir {
+	return Dir(filepath.Dir(filepath.Dir(msg.filename)))
 }
 
 // MoveTo moves a message from this Maildir to another one.
 //
-// The message flags are preserved, but its key might change.
+// The message flags are preserved, but its key might change. The target is
+// stored in the same FS as the message. Keyword letters are translated to
+// the keyword table of the target.
+//
+// If the message leaves a Maildir++ quota root, the move is recorded in the
+// quota of both the source and the target. The target quota is not enforced.
 func (msg *Message) MoveTo(target Dir) error {
-	newFilename := filepath.Join(string(target), "cur", filepath.Base(msg.filename))
-	if err := os.Rename(msg.filename, newFilename); err != nil {
+	src := msg.dir()
+	updateQuota := isOSFS(msg.fsys()) &&
+		filepath.Clean(string(src.quotaRoot())) != filepath.Clean(string(target.quotaRoot()))
+	var size int64
+	if updateQuota {
+		var err error
+		if size, err = msg.Size(); err != nil {
+			return err
+		}
+	}
+
+	flags, changed, err := msg.targetFlags(target)
+	if err != nil {
 		return err
 	}
+	basename := filepath.Base(msg.filename)
+	if msg.Subdir() == "new" || changed {
+		// Files in new have no info section, which is required in cur,
+		// and keyword letters might have been translated
+		basename = formatBasename(msg.key, flags)
+	}
+	newFilename := filepath.Join(string(target), "cur", basename)
+	if err := msg.fsys().Rename(msg.filename, newFilename); err != nil {
+		return err
+	}
+	msg.updateIndex(true)
 	msg.filename = newFilename
+	msg.flags = flags
+	msg.updateIndex(false)
+	if updateQuota {
+		// Failures to update the quota files are fixed by the next
+		// recalculation
+		src.addQuotaUsage(-size, -1)
+		target.addQuotaUsage(size, 1)
+	}
 	return nil
 }
 
 // CopyTo copies a message from this Maildir to another one.
 //
 // The copied message is returned. Its flags will be identical but its key
-// might be different.
+// might be different. The target is stored in the same FS as the message.
+// Keyword letters are translated to the keyword table of the target.
+//
+// The copy is written to tmp and only published to cur once complete, so a
+// failed copy never leaves a partial message in the target.
 func (msg *Message) CopyTo(target Dir) (*Message, error) {
 	src, err := msg.Open()
 	if err != nil {
@@ -194,32 +359,111 @@ func (msg *Message) CopyTo(target Dir) (*Message, error) {
 	}
 	defer src.Close()
 
-	newMsg, dst, err := target.Create(msg.flags)
+	flags, _, err := msg.targetFlags(target)
+	if err != nil {
+		return nil, err
+	}
+	newMsg, dst, err := FSDir{FS: msg.fs, Path: string(target)}.Create(flags)
 	if err != nil {
 		return nil, err
 	}
-	defer dst.Close()
 
 	if _, err = io.Copy(dst, src); err != nil {
+		dst.Abort()
 		return nil, err
 	}
 	if err := dst.Close(); err != nil {
+		dst.Abort()
 		return nil, err
 	}
 
 	return newMsg, nil
 }
 
-type tmpMessage struct {
-	*os.File
-	dest string
+// MessageWriter writes a new message created with Dir.Create. It implements
+// the io.WriteCloser interface. The message is written to tmp, and published
+// to cur on Close. Abort cancels the message instead.
+//
+// If the mailbox has a Maildir++ quota, messages exceeding it are rejected
+// with a *QuotaError.
+type MessageWriter struct {
+	file    File
+	msg     *Message
+	counter sizeCounter
+	quota   bool
+	options DeliveryOptions
+}
+
+// Write implements io.Writer.
+func (w *MessageWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.counter.Write(p[:n])
+	return n, err
+}
+
+// Close closes the underlying file and moves it to cur.
+//
+// If the message would exceed the Maildir++ quota, it is removed and a
+// *QuotaError is returned.
+func (w *MessageWriter) Close() error {
+	if err := closeFile(w.file, w.options.Sync); err != nil {
+		return err
+	}
+	if !w.options.ModTime.IsZero() {
+		if err := setModTime(w.msg.fsys(), w.file.Name(), w.options.ModTime); err != nil {
+			return err
+		}
+	}
+	if w.quota {
+		if err := w.msg.dir().checkQuota(w.counter.size); err != nil {
+			w.msg.fsys().Remove(w.file.Name())
+			return err
+		}
+	}
+
+	msg := w.msg
+	var attrs string
+	if w.options.SizeAttributes {
+		attrs = w.counter.sizeAttrs()
+	}
+	dir := filepath.Dir(msg.filename)
+	key, err := publishFile(msg.fsys(), w.file.Name(), dir, msg.key, func(key string) string {
+		return formatBasename(key+attrs, msg.flags)
+	}, &w.options)
+	if err != nil {
+		return err
+	}
+	msg.key = key + attrs
+	msg.filename = filepath.Join(dir, formatBasename(msg.key, msg.flags))
+	msg.updateIndex(false)
+	if w.quota {
+		// The message has been published, a failure to update the quota
+		// file is fixed by the next recalculation
+		msg.dir().addQuotaUsage(w.counter.size, 1)
+	}
+	return nil
+}
+
+// Key returns the key of the message. The key might change when the message
+// is published on Close.
+func (w *MessageWriter) Key() string {
+	return w.msg.key
+}
+
+// Sync commits the data written so far to stable storage.
+func (w *MessageWriter) Sync() error {
+	return w.file.Sync()
 }
 
-func (msg tmpMessage) Close() error {
-	if err := msg.File.Close(); err != nil {
+// Abort closes the underlying file and removes it from tmp. The message is
+// not published.
+//
+// Abort can be called after a failed Close, to remove the file left in tmp.
+func (w *MessageWriter) Abort() error {
+	if err := w.file.Close(); err != nil && !errors.Is(err, fs.ErrClosed) {
 		return err
 	}
-	return os.Rename(msg.File.Name(), msg.dest)
+	return w.msg.fsys().Remove(w.file.Name())
 }
 
 // A Dir represents a single directory in a Maildir mailbox.
@@ -227,71 +471,217 @@ func (msg tmpMessage) Close() error {
 // Dir is used by programs receiving and reading messages from a Maildir. Only
 // one process can perform these operations. Programs which only need to
 // deliver new messages to the Maildir should use Delivery.
+//
+// A Dir is stored in the OS file system. Use FSDir for other file systems.
 type Dir string
 
-func (d Dir) newMessage(dir, basename string) (*Message, error) {
+// fsDir returns the FSDir for d.
+func (d Dir) fsDir() FSDir {
+	return FSDir{FS: OSFS{}, Path: string(d)}
+}
+
+// FSDir is a Dir stored in a FS.
+//
+// The Dir methods are available on FSDir as well. Features tied to the OS
+// file system, such as quotas, keywords and the key index, are only
+// available on Dir.
+type FSDir struct {
+	FS   FS     // the file system, nil for OSFS
+	Path string // the path to the Maildir in FS
+}
+
+func (d FSDir) fsys() FS {
+	if d.FS == nil {
+		return OSFS{}
+	}
+	return d.FS
+}
+
+// join joins the Maildir path and the provided elements.
+func (d FSDir) join(elem ...string) string {
+	return filepath.Join(append([]string{d.Path}, elem...)...)
+}
+
+func (d FSDir) newMessage(dir, basename string) (*Message, error) {
 	key, flags, err := parseBasename(basename)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Message{
+		fs:       d.FS,
 		filename: filepath.Join(dir, basename),
 		key:      key,
 		flags:    flags,
 	}, nil
 }
 
+// newUnseenMessage creates a message for a file in new. Such files usually
+// don't have an info section, so the message has no flags.
+func (d FSDir) newUnseenMessage(basename string) *Message {
+	key, _, _ := strings.Cut(basename, string(separator))
+	return &Message{
+		fs:       d.FS,
+		filename: d.join("new", basename),
+		key:      key,
+	}
+}
+
 // Unseen moves messages from new to cur and returns them.
-// This means the messages are now known to the application.
+// This means the messages are now known to the application. The info
+// section of files in new is discarded, see UnseenWithOptions.
+//
+// If a file cannot be moved, for instance because it has been removed by
+// another client in the meantime, Unseen accumulates errors and continues.
+// All moved messages are returned, along with the joined errors.
 func (d Dir) Unseen() ([]*Message, error) {
-	f, err := os.Open(filepath.Join(string(d), "new"))
+	return d.fsDir().Unseen()
+}
+
+// UnseenContext is like Unseen, but stops when ctx is done. The messages
+// moved so far are returned along with the context error.
+func (d Dir) UnseenContext(ctx context.Context) ([]*Message, error) {
+	return d.fsDir().UnseenContext(ctx)
+}
+
+// UnseenWithOptions is like UnseenContext, with the provided options. A nil
+// options pointer is equivalent to UnseenContext.
+func (d Dir) UnseenWithOptions(ctx context.Context, options *UnseenOptions) ([]*Message, error) {
+	return d.fsDir().UnseenWithOptions(ctx, options)
+}
+
+// InfoPolicy specifies how an info section of a file in new is handled when
+// the file is moved to cur.
+//
+// Messages in new shouldn't have an info section, but some programs (e.g.
+// offlineimap) add one anyways.
+type InfoPolicy int
+
+const (
+	// Discard the info section.
+	InfoDiscard InfoPolicy = iota
+	// Keep a valid info section as is.
+	InfoKeep
+	// Keep the flags of a valid info section and add the default flags.
+	InfoMerge
+)
+
+// UnseenOptions contains options for Dir.UnseenWithOptions.
+type UnseenOptions struct {
+	// The policy for info sections of files in new. Invalid info sections,
+	// such as experimental ones, are always discarded.
+	Info InfoPolicy
+	// The default flags of the messages moved to cur. They are used when
+	// the info section is discarded, and added to its flags with
+	// InfoMerge.
+	Flags []Flag
+}
+
+// curBasename returns the basename of a file in new once moved to cur.
+func (options *UnseenOptions) curBasename(basename string) string {
+	key, _, _ := strings.Cut(basename, string(separator))
+	flags := append([]Flag(nil), options.Flags...)
+	if options.Info != InfoDiscard {
+		if _, infoFlags, err := parseBasename(basename); err == nil {
+			if options.Info == InfoKeep {
+				flags = infoFlags
+			} else {
+				flags = append(flags, infoFlags...)
+			}
+		}
+	}
+	return formatBasename(key, flags)
+}
+
+// Unseen is like Dir.Unseen.
+func (d FSDir) Unseen() ([]*Message, error) {
+	return d.UnseenContext(context.Background())
+}
+
+// UnseenContext is like Dir.UnseenContext.
+func (d FSDir) UnseenContext(ctx context.Context) ([]*Message, error) {
+	return d.UnseenWithOptions(ctx, nil)
+}
+
+// UnseenWithOptions is like Dir.UnseenWithOptions.
+func (d FSDir) UnseenWithOptions(ctx context.Context, options *UnseenOptions) ([]*Message, error) {
+	if options == nil {
+		options = new(UnseenOptions)
+	}
+
+	f, err := d.fsys().Open(d.join("new"))
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	var msgs []*Message
+	var (
+		msgs []*Message
+		errs []error
+	)
+	if isOSFS(d.fsys()) {
+		defer func() {
+			// Index errors are ignored: a stale index is repaired on lookup
+			entries := make([]indexEntry, len(msgs))
+			for i, msg := range msgs {
+				entries[i] = indexEntry{msg.key, filepath.Base(msg.filename)}
+			}
+			Dir(d.Path).updateIndex(entries...)
+		}()
+	}
+
 	for {
-		names, err := f.Readdirnames(readdirChunk)
+		if err := ctx.Err(); err != nil {
+			return msgs, errors.Join(append(errs, err)...)
+		}
+		names, err := readDirNames(f, readdirChunk)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return msgs, err
+			return msgs, errors.Join(append(errs, err)...)
 		}
 		for _, n := range names {
 			if n[0] == '.' {
 				continue
 			}
 
-			// Messages in new shouldn't have an info field, but some programs
-			// (e.g. offlineimap) do that anyways. Discard the info field in
-			// that case.
-			key, _, _ := strings.Cut(n, string(separator))
-			info := "2,"
-			newBasename := key + string(separator) + info
-
-			err = os.Rename(filepath.Join(string(d), "new", n),
-				filepath.Join(string(d), "cur", newBasename))
+			msg, err := d.newMessage(d.join("cur"), options.curBasename(n))
 			if err != nil {
-				return msgs, err
+				errs = append(errs, err)
+				continue
 			}
 
-			msg, err := d.newMessage(filepath.Join(string(d), "cur"), newBasename)
+			err = d.fsys().Rename(d.join("new", n), msg.filename)
 			if err != nil {
-				panic(err) // unreachable
+				errs = append(errs, err)
+				continue
 			}
 
 			msgs = append(msgs, msg)
 		}
 	}
 
-	return msgs, nil
+	return msgs, errors.Join(errs...)
 }
 
 // UnseenCount returns the number of messages in new without looking at them.
 func (d Dir) UnseenCount() (int, error) {
-	f, err := os.Open(filepath.Join(string(d), "new"))
+	return d.fsDir().UnseenCount()
+}
+
+// UnseenCountContext is like UnseenCount, but stops when ctx is done.
+func (d Dir) UnseenCountContext(ctx context.Context) (int, error) {
+	return d.fsDir().UnseenCountContext(ctx)
+}
+
+// UnseenCount is like Dir.UnseenCount.
+func (d FSDir) UnseenCount() (int, error) {
+	return d.UnseenCountContext(context.Background())
+}
+
+// UnseenCountContext is like Dir.UnseenCountContext.
+func (d FSDir) UnseenCountContext(ctx context.Context) (int, error) {
+	f, err := d.fsys().Open(d.join("new"))
 	if err != nil {
 		return 0, err
 	}
@@ -299,7 +689,10 @@ func (d Dir) UnseenCount() (int, error) {
 
 	c := 0
 	for {
-		names, err := f.Readdirnames(readdirChunk)
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		names, err := readDirNames(f, readdirChunk)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
@@ -322,7 +715,23 @@ func (d Dir) UnseenCount() (int, error) {
 // iterating. If fn returns an error, Walk stops and returns a new error that
 // contains fn's error in its tree (and can be checked via errors.Is).
 func (d Dir) Walk(fn func(*Message) error) error {
-	f, err := os.Open(filepath.Join(string(d), "cur"))
+	return d.fsDir().Walk(fn)
+}
+
+// WalkContext is like Walk, but stops when ctx is done. The context error is
+// returned in the error tree.
+func (d Dir) WalkContext(ctx context.Context, fn func(*Message) error) error {
+	return d.fsDir().WalkContext(ctx, fn)
+}
+
+// Walk is like Dir.Walk.
+func (d FSDir) Walk(fn func(*Message) error) error {
+	return d.WalkContext(context.Background(), fn)
+}
+
+// WalkContext is like Dir.WalkContext.
+func (d FSDir) WalkContext(ctx context.Context, fn func(*Message) error) error {
+	f, err := d.fsys().Open(d.join("cur"))
 	if err != nil {
 		return err
 	}
@@ -330,7 +739,10 @@ func (d Dir) Walk(fn func(*Message) error) error {
 
 	var formatErrs []error
 	for {
-		names, err := f.Readdirnames(readdirChunk)
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(formatErrs, err)...)
+		}
+		names, err := readDirNames(f, readdirChunk)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
@@ -357,8 +769,51 @@ func (d Dir) Walk(fn func(*Message) error) error {
 	return errors.Join(formatErrs...)
 }
 
+// WalkNew calls fn for every message in new. Unlike Unseen, the messages are
+// left in new. Their key is the file name without any info section, and they
+// have no flags.
+//
+// If fn returns an error, WalkNew stops and returns it.
+func (d Dir) WalkNew(fn func(*Message) error) error {
+	return d.fsDir().WalkNew(fn)
+}
+
+// WalkNew is like Dir.WalkNew.
+func (d FSDir) WalkNew(fn func(*Message) error) error {
+	f, err := d.fsys().Open(d.join("new"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		names, err := readDirNames(f, readdirChunk)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		for _, n := range names {
+			if n[0] == '.' {
+				continue
+			}
+			if err := fn(d.newUnseenMessage(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // Messages returns a list of all messages in cur.
 func (d Dir) Messages() ([]*Message, error) {
+	return d.fsDir().Messages()
+}
+
+// Messages is like Dir.Messages.
+func (d FSDir) Messages() ([]*Message, error) {
 	var msgs []*Message
 	err := d.Walk(func(msg *Message) error {
 		msgs = append(msgs, msg)
@@ -367,8 +822,8 @@ func (d Dir) Messages() ([]*Message, error) {
 	return msgs, err
 }
 
-func (d Dir) filenameGuesses(key string) []string {
-	filename := filepath.Join(string(d), "cur", key+string(separator)+"2,")
+func (d FSDir) filenameGuesses(key string) []string {
+	filename := d.join("cur", key+string(separator)+"2,")
 	return []string{
 		filename,
 
@@ -392,16 +847,37 @@ func (d Dir) filenameGuesses(key string) []string {
 }
 
 // filenameByKey returns the path to the file corresponding to the key.
-func (d Dir) filenameByKey(key string) (string, error) {
+func (d FSDir) filenameByKey(ctx context.Context, key string) (string, error) {
+	if !isOSFS(d.fsys()) {
+		return d.searchFilename(ctx, key)
+	}
+
+	filename, indexed, err := Dir(d.Path).indexFilename(key)
+	if !indexed {
+		return d.searchFilename(ctx, key)
+	} else if err != nil || filename != "" {
+		return filename, err
+	}
+	// The key is missing from the index: only rebuild it if the key exists
+	filename, err = d.searchFilename(ctx, key)
+	if err == nil {
+		// Index errors are ignored: the key has been found anyways
+		Dir(d.Path).repairIndex()
+	}
+	return filename, err
+}
+
+// searchFilename searches cur for the file corresponding to the key.
+func (d FSDir) searchFilename(ctx context.Context, key string) (string, error) {
 	// before doing an expensive Glob, see if we can guess the path based on some
 	// common flags
 	for _, guess := range d.filenameGuesses(key) {
-		if _, err := os.Stat(guess); err == nil {
+		if _, err := d.fsys().Stat(guess); err == nil {
 			return guess, nil
 		}
 	}
 
-	file, err := os.Open(filepath.Join(string(d), "cur"))
+	file, err := d.fsys().Open(d.join("cur"))
 	if err != nil {
 		return "", err
 	}
@@ -409,7 +885,10 @@ func (d Dir) filenameByKey(key string) (string, error) {
 
 	// search for a valid candidate (in blocks of readdirChunk)
 	for {
-		names, err := file.Readdirnames(readdirChunk)
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		names, err := readDirNames(file, readdirChunk)
 		if errors.Is(err, io.EOF) {
 			// no match
 			return "", &KeyError{key, 0}
@@ -427,7 +906,22 @@ func (d Dir) filenameByKey(key string) (string, error) {
 
 // MessageByKey finds a message by key.
 func (d Dir) MessageByKey(key string) (*Message, error) {
-	filename, err := d.filenameByKey(key)
+	return d.fsDir().MessageByKey(key)
+}
+
+// MessageByKeyContext is like MessageByKey, but stops when ctx is done.
+func (d Dir) MessageByKeyContext(ctx context.Context, key string) (*Message, error) {
+	return d.fsDir().MessageByKeyContext(ctx, key)
+}
+
+// MessageByKey is like Dir.MessageByKey.
+func (d FSDir) MessageByKey(key string) (*Message, error) {
+	return d.MessageByKeyContext(context.Background(), key)
+}
+
+// MessageByKeyContext is like Dir.MessageByKeyContext.
+func (d FSDir) MessageByKeyContext(ctx context.Context, key string) (*Message, error) {
+	filename, err := d.filenameByKey(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -440,12 +934,10 @@ func (d Dir) MessageByKey(key string) (*Message, error) {
 // counter, the process id and a cryptographical random number to ensure
 // uniqueness among messages delivered in the same second.
 func newKey() (string, error) {
-	host, err := os.Hostname()
+	host, err := escapedHostname()
 	if err != nil {
 		return "", err
 	}
-	host = strings.Replace(host, "/", `\057`, -1)
-	host = strings.Replace(host, string(separator), `\072`, -1)
 
 	bs := make([]byte, 10)
 	_, err = io.ReadFull(rand.Reader, bs)
@@ -469,14 +961,19 @@ func newKey() (string, error) {
 // in there. If an error occurs while creating one of the subdirectories, this
 // function may leave a partially created directory structure.
 func (d Dir) Init() error {
+	return d.fsDir().Init()
+}
+
+// Init is like Dir.Init.
+func (d FSDir) Init() error {
 	dirnames := []string{
-		string(d),
-		filepath.Join(string(d), "tmp"),
-		filepath.Join(string(d), "new"),
-		filepath.Join(string(d), "cur"),
+		d.Path,
+		d.join("tmp"),
+		d.join("new"),
+		d.join("cur"),
 	}
 	for _, name := range dirnames {
-		if err := os.Mkdir(name, 0700); err != nil && !os.IsExist(err) {
+		if err := d.fsys().Mkdir(name, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
 			return err
 		}
 	}
@@ -484,120 +981,509 @@ func (d Dir) Init() error {
 }
 
 // Create inserts a new message into the Maildir.
-func (d Dir) Create(flags []Flag) (*Message, io.WriteCloser, error) {
-	key, err := newKey()
+//
+// If the mailbox is already over its Maildir++ quota, a *QuotaError is
+// returned.
+func (d Dir) Create(flags []Flag) (*Message, *MessageWriter, error) {
+	return d.CreateWithOptions(flags, nil)
+}
+
+// Create is like Dir.Create.
+func (d FSDir) Create(flags []Flag) (*Message, *MessageWriter, error) {
+	return d.CreateWithOptions(flags, nil)
+}
+
+// CreateWithOptions inserts a new message into the Maildir with the provided
+// options. A nil options pointer is equivalent to Create.
+//
+// If size attributes are enabled, the key of the returned message is updated
+// when the writer is closed.
+func (d Dir) CreateWithOptions(flags []Flag, options *DeliveryOptions) (*Message, *MessageWriter, error) {
+	return d.fsDir().CreateWithOptions(flags, options)
+}
+
+// CreateWithOptions is like Dir.CreateWithOptions.
+func (d FSDir) CreateWithOptions(flags []Flag, options *DeliveryOptions) (*Message, *MessageWriter, error) {
+	if options == nil {
+		options = new(DeliveryOptions)
+	}
+	if options.Subdir != "" && options.Subdir != "cur" {
+		return nil, nil, fmt.Errorf("maildir: invalid sub-directory %q for a created message", options.Subdir)
+	}
+	if len(options.Flags) > 0 {
+		return nil, nil, errors.New("maildir: flags of a created message must be passed as an argument")
+	}
+
+	hasQuota, err := d.checkNewQuota()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	tmpFilename := filepath.Join(string(d), "tmp", key)
-	f, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+	f, key, err := d.createTmpFile(options)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	basename := formatBasename(key, flags)
-	curFilename := filepath.Join(string(d), "cur", basename)
+	curFilename := d.join("cur", basename)
 
 	flagsCopy := make([]Flag, len(flags))
 	copy(flagsCopy, flags)
 
-	return &Message{
+	msg := &Message{
+		fs:       d.FS,
 		filename: curFilename,
 		key:      key,
 		flags:    flagsCopy,
-	}, &tmpMessage{File: f, dest: curFilename}, err
+	}
+	return msg, &MessageWriter{file: f, msg: msg, quota: hasQuota, options: *options}, nil
 }
 
+// createTmpFile creates a new message file in tmp. It returns the file and
+// the key of the message.
+func (d FSDir) createTmpFile(options *DeliveryOptions) (File, string, error) {
+	tmpKey, err := newKey()
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := d.fsys().OpenFile(d.join("tmp", tmpKey), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, "", err
+	}
+	if options.KeyGenerator == nil {
+		return f, tmpKey, nil
+	}
+
+	key, err := options.newKey(d.fsys(), f.Name())
+	if err != nil {
+		f.Close()
+		d.fsys().Remove(f.Name())
+		return nil, "", err
+	}
+	return f, key, nil
+}
+
+// newKey generates a key for a message written to the file tmpFilename in
+// tmp, with the configured KeyGenerator.
+func (options *DeliveryOptions) newKey(fsys FS, tmpFilename string) (string, error) {
+	if options.KeyGenerator == nil {
+		return newKey()
+	}
+	fi, err := fsys.Stat(tmpFilename)
+	if err != nil {
+		return "", err
+	}
+	return options.KeyGenerator.NewKey(fi)
+}
+
+// cleanMaxAge is the default age of the files removed by Clean, as
+// recommended by the maildir specification.
+const cleanMaxAge = 36 * time.Hour
+
 // Clean removes old files from tmp and should be run periodically.
 // This does not use access time but modification time for portability reasons.
 func (d Dir) Clean() error {
-	f, err := os.Open(filepath.Join(string(d), "tmp"))
+	return d.fsDir().Clean()
+}
+
+// CleanContext is like Clean, but stops when ctx is done.
+func (d Dir) CleanContext(ctx context.Context) error {
+	return d.fsDir().CleanContext(ctx)
+}
+
+// CleanWithOptions is like CleanContext, with the provided options. A nil
+// options pointer is equivalent to CleanContext.
+//
+// A report of the removed files is returned, even if an error occurs.
+func (d Dir) CleanWithOptions(ctx context.Context, options *CleanOptions) (*CleanReport, error) {
+	return d.fsDir().CleanWithOptions(ctx, options)
+}
+
+// CleanOptions contains options for Dir.CleanWithOptions.
+type CleanOptions struct {
+	// Files modified more than MaxAge ago are removed. If zero, 36 hours is
+	// used.
+	MaxAge time.Duration
+	// The clock used to compute the age of files. If nil, time.Now is used.
+	Now func() time.Time
+	// Report the files which would be removed, without removing them.
+	DryRun bool
+	// Continue when a file cannot be removed, instead of stopping at the
+	// first error. The errors are joined.
+	ContinueOnError bool
+}
+
+// CleanReport describes the files removed by Dir.CleanWithOptions.
+type CleanReport struct {
+	Files []string // the paths of the removed files
+	Size  int64    // the total size of the removed files in bytes
+}
+
+// Clean is like Dir.Clean.
+func (d FSDir) Clean() error {
+	return d.CleanContext(context.Background())
+}
+
+// CleanContext is like Dir.CleanContext.
+func (d FSDir) CleanContext(ctx context.Context) error {
+	_, err := d.CleanWithOptions(ctx, nil)
+	return err
+}
+
+// CleanWithOptions is like Dir.CleanWithOptions.
+func (d FSDir) CleanWithOptions(ctx context.Context, options *CleanOptions) (*CleanReport, error) {
+	if options == nil {
+		options = new(CleanOptions)
+	}
+	maxAge := options.MaxAge
+	if maxAge == 0 {
+		maxAge = cleanMaxAge
+	}
+	now := time.Now
+	if options.Now != nil {
+		now = options.Now
+	}
+
+	report := &CleanReport{}
+	f, err := d.fsys().Open(d.join("tmp"))
 	if err != nil {
-		return err
+		return report, err
 	}
 	defer f.Close()
 
-	now := time.Now()
+	t := now()
+	var errs []error
 	for {
-		names, err := f.Readdirnames(readdirChunk)
+		if err := ctx.Err(); err != nil {
+			return report, errors.Join(append(errs, err)...)
+		}
+		names, err := readDirNames(f, readdirChunk)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return err
+			return report, errors.Join(append(errs, err)...)
 		}
 
 		for _, n := range names {
-			fi, err := os.Stat(filepath.Join(string(d), "tmp", n))
-			if err != nil {
+			filename := d.join("tmp", n)
+			fi, err := d.fsys().Stat(filename)
+			if err != nil || t.Sub(fi.ModTime()) <= maxAge {
 				continue
 			}
-			if now.Sub(fi.ModTime()).Hours() > 36 {
-				err = os.Remove(filepath.Join(string(d), "tmp", n))
-				if err != nil {
-					return err
+			if !options.DryRun {
+				if err := d.fsys().Remove(filename); err != nil {
+					errs = append(errs, err)
+					if !options.ContinueOnError {
+						return report, errors.Join(errs...)
+					}
+					continue
 				}
 			}
+			report.Files = append(report.Files, filename)
+			report.Size += fi.Size()
 		}
 	}
 
-	return nil
+	return report, errors.Join(errs...)
 }
 
 // Delivery represents an ongoing message delivery to the mailbox. It
 // implements the io.WriteCloser interface. On Close the underlying file is
-// moved/relinked to new.
+// moved/relinked to new, or to cur if requested with DeliveryOptions.
 //
 // Multiple processes can perform a delivery on the same Maildir concurrently.
+//
+// If the mailbox has a Maildir++ quota, deliveries exceeding it are rejected
+// with a *QuotaError.
 type Delivery struct {
-	file *os.File
-	d    Dir
-	key  string
+	file    File
+	d       FSDir
+	key     string
+	counter sizeCounter
+	quota   bool
+	options DeliveryOptions
+}
+
+// DeliveryOptions contains options for NewDeliveryWithOptions and
+// Dir.CreateWithOptions.
+type DeliveryOptions struct {
+	// Append the message size (S=) and RFC 822 size (W=) attributes to the
+	// key, so that Message.Size and Message.VirtualSize don't need to access
+	// the file.
+	SizeAttributes bool
+	// Flush the message to disk before publishing it, and flush the
+	// destination directory afterwards, so that the message survives a
+	// power failure once Close returns. Failures are reported with a
+	// *DeliveryError.
+	Sync bool
+	// Publish the message with link() and unlink() instead of rename(), as
+	// recommended by the maildir specification. If the destination already
+	// exists, the message is published with a fresh key instead of replacing
+	// the existing file. Rename is used on file systems without hard links.
+	Link bool
+	// The generator for the message key. If nil, ClassicKeyGenerator is
+	// used.
+	KeyGenerator KeyGenerator
+	// The sub-directory deliveries are published to, "new" or "cur". If
+	// empty, "new" is used. Dir.CreateWithOptions always publishes to cur
+	// and rejects any other value.
+	Subdir string
+	// The flags of a delivery published to cur. Dir.CreateWithOptions takes
+	// the flags as an argument instead and rejects this field.
+	Flags []Flag
+	// The modification time of the message file. If zero, the time of the
+	// delivery is kept. The FS needs to implement ChtimesFS.
+	ModTime time.Time
 }
 
 // NewDelivery creates a new Delivery.
+//
+// If the mailbox is already over its Maildir++ quota, a *QuotaError is
+// returned.
 func NewDelivery(d string) (*Delivery, error) {
-	key, err := newKey()
+	return NewDeliveryWithOptions(d, nil)
+}
+
+// NewDeliveryWithOptions creates a new Delivery with the provided options. A
+// nil options pointer is equivalent to NewDelivery.
+func NewDeliveryWithOptions(d string, options *DeliveryOptions) (*Delivery, error) {
+	return FSDir{FS: OSFS{}, Path: d}.NewDelivery(options)
+}
+
+// NewDelivery creates a new Delivery to the Maildir with the provided
+// options. A nil options pointer is equivalent to the default options.
+//
+// Quotas are only enforced on OSFS.
+func (d FSDir) NewDelivery(options *DeliveryOptions) (*Delivery, error) {
+	if options == nil {
+		options = new(DeliveryOptions)
+	}
+	switch options.Subdir {
+	case "", "new":
+		if len(options.Flags) > 0 {
+			return nil, errors.New("maildir: flags can only be set on deliveries to cur")
+		}
+	case "cur":
+		// ok
+	default:
+		return nil, fmt.Errorf("maildir: invalid delivery sub-directory %q", options.Subdir)
+	}
+
+	hasQuota, err := d.checkNewQuota()
 	if err != nil {
 		return nil, err
 	}
-	del := &Delivery{}
-	filename := filepath.Join(d, "tmp", key)
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+
+	file, key, err := d.createTmpFile(options)
 	if err != nil {
 		return nil, err
 	}
+	del := &Delivery{}
 	del.file = file
-	del.d = Dir(d)
+	del.d = d
 	del.key = key
+	del.quota = hasQuota
+	del.options = *options
+	del.options.Flags = append([]Flag(nil), options.Flags...)
 	return del, nil
 }
 
+// Key returns the key of the message. The key might change when the message
+// is published on Close.
+func (d *Delivery) Key() string {
+	return d.key
+}
+
+// checkNewQuota returns a QuotaError if the Maildir++ quota doesn't allow one
+// more message. It reports whether the Maildir has a quota. Quotas are only
+// enforced on OSFS.
+func (d FSDir) checkNewQuota() (bool, error) {
+	if !isOSFS(d.fsys()) {
+		return false, nil
+	}
+	q, usage, err := Dir(d.Path).Quota()
+	if err != nil {
+		return false, err
+	}
+	usage.Count++
+	if q.exceeded(usage) {
+		return false, &QuotaError{Quota: q, Usage: usage}
+	}
+	return q != (Quota{}), nil
+}
+
 // Write implements io.Writer.
 func (d *Delivery) Write(p []byte) (int, error) {
-	return d.file.Write(p)
+	n, err := d.file.Write(p)
+	d.counter.Write(p[:n])
+	return n, err
 }
 
-// Close closes the underlying file and moves it to new.
+// Close closes the underlying file and moves it to new, or cur.
+//
+// If the message would exceed the Maildir++ quota, it is removed and a
+// *QuotaError is returned.
 func (d *Delivery) Close() error {
 	tmppath := d.file.Name()
-	err := d.file.Close()
-	if err != nil {
+	if err := closeFile(d.file, d.options.Sync); err != nil {
 		return err
 	}
-	newfile := filepath.Join(string(d.d), "new", d.key)
-	if err = os.Rename(tmppath, newfile); err != nil {
+	if !d.options.ModTime.IsZero() {
+		if err := setModTime(d.d.fsys(), tmppath, d.options.ModTime); err != nil {
+			return err
+		}
+	}
+	if d.quota {
+		if err := Dir(d.d.Path).checkQuota(d.counter.size); err != nil {
+			d.d.fsys().Remove(tmppath)
+			return err
+		}
+	}
+	var attrs string
+	if d.options.SizeAttributes {
+		attrs = d.counter.sizeAttrs()
+	}
+	basename := func(key string) string {
+		return key + attrs
+	}
+	sub := "new"
+	if d.options.Subdir == "cur" {
+		sub = "cur"
+		basename = func(key string) string {
+			return formatBasename(key+attrs, d.options.Flags)
+		}
+	}
+	key, err := publishFile(d.d.fsys(), tmppath, d.d.join(sub), d.key, basename, &d.options)
+	if err != nil {
 		return err
 	}
+	d.key = key + attrs
+	if sub == "cur" && isOSFS(d.d.fsys()) {
+		updateMessageIndex(d.d.join(sub, basename(key)), d.key, false)
+	}
+	if d.quota {
+		// The message has been delivered, a failure to update the quota file
+		// is fixed by the next recalculation
+		Dir(d.d.Path).addQuotaUsage(d.counter.size, 1)
+	}
 	return nil
 }
 
+// DeliveryStep is a step of the publication of a message written to tmp.
+type DeliveryStep string
+
+const (
+	// The message file is flushed to disk.
+	DeliveryStepSyncFile DeliveryStep = "sync file"
+	// The message file is closed.
+	DeliveryStepClose DeliveryStep = "close"
+	// The message file is moved from tmp to its destination.
+	DeliveryStepRename DeliveryStep = "rename"
+	// The message file is linked from tmp to its destination.
+	DeliveryStepLink DeliveryStep = "link"
+	// The destination directory is flushed to disk.
+	DeliveryStepSyncDir DeliveryStep = "sync directory"
+	// The modification time of the message file is set.
+	DeliveryStepModTime DeliveryStep = "set modification time"
+)
+
+// A DeliveryError occurs when a step of the publication of a message fails.
+//
+// If the error occurred before or at DeliveryStepRename or DeliveryStepLink,
+// the message has not been delivered. If it occurred at DeliveryStepSyncDir,
+// the message has been delivered but might be lost on power failure.
+// DeliveryStepModTime happens before the message is published, so the message
+// has not been delivered either.
+type DeliveryError struct {
+	Step DeliveryStep // the failed step
+	Err  error        // the underlying error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("maildir: delivery failed (%v): %v", e.Step, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// closeFile closes a message file written to tmp. If sync is true, the file
+// is flushed to disk first.
+func closeFile(f File, sync bool) error {
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return &DeliveryError{DeliveryStepSyncFile, err}
+		}
+	}
+	if err := f.Close(); err != nil {
+		return &DeliveryError{DeliveryStepClose, err}
+	}
+	return nil
+}
+
+// setModTime sets the modification time of a closed message file in tmp.
+func setModTime(fsys FS, name string, mtime time.Time) error {
+	chtimesFS, ok := fsys.(ChtimesFS)
+	if !ok {
+		return &DeliveryError{DeliveryStepModTime, errors.New("file system doesn't support modification times")}
+	}
+	if err := chtimesFS.Chtimes(name, mtime, mtime); err != nil {
+		return &DeliveryError{DeliveryStepModTime, err}
+	}
+	return nil
+}
+
+// maxLinkAttempts is the number of keys tried when publishing a message with
+// link before giving up.
+const maxLinkAttempts = 10
+
+// publishFile moves a closed message file from tmp to dir in fsys. The
+// destination file name is built from the message key with basename.
+//
+// If the Link option is set and the destination already exists, a fresh key
+// is generated and publication is retried. The final key is returned.
+func publishFile(fsys FS, tmpFilename, dir, key string, basename func(key string) string, options *DeliveryOptions) (string, error) {
+	if !options.Link {
+		if err := fsys.Rename(tmpFilename, filepath.Join(dir, basename(key))); err != nil {
+			return "", &DeliveryError{DeliveryStepRename, err}
+		}
+	} else {
+		for attempt := 1; ; attempt++ {
+			err := fsys.Link(tmpFilename, filepath.Join(dir, basename(key)))
+			if err == nil {
+				// A leftover file in tmp is removed by Clean
+				fsys.Remove(tmpFilename)
+				break
+			} else if isLinkUnsupported(err) {
+				if err := fsys.Rename(tmpFilename, filepath.Join(dir, basename(key))); err != nil {
+					return "", &DeliveryError{DeliveryStepRename, err}
+				}
+				break
+			} else if !errors.Is(err, os.ErrExist) || attempt == maxLinkAttempts {
+				return "", &DeliveryError{DeliveryStepLink, err}
+			}
+
+			if key, err = options.newKey(fsys, tmpFilename); err != nil {
+				return "", &DeliveryError{DeliveryStepLink, err}
+			}
+		}
+	}
+
+	if options.Sync {
+		if err := syncFSDir(fsys, dir); err != nil {
+			return "", &DeliveryError{DeliveryStepSyncDir, err}
+		}
+	}
+	return key, nil
+}
+
 // Abort closes the underlying file and removes it completely.
+//
+// Abort can be called after a failed Close, to remove the file left in tmp.
 func (d *Delivery) Abort() error {
-	tmppath := d.file.Name()
-	err := d.file.Close()
-	if err != nil {
+	if err := d.file.Close(); err != nil && !errors.Is(err, fs.ErrClosed) {
 		return err
 	}
-	return os.Remove(tmppath)
+	return d.d.fsys().Remove(d.file.Name())
 }
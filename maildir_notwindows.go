@@ -2,7 +2,24 @@
 
 package maildir
 
+import (
+	"os"
+)
+
 // The separator separates a messages unique key from its flags in the filename.
 // This should only be changed on operating systems where the colon isn't
 // allowed in filenames.
 const separator rune = ':'
+
+// syncDir flushes a directory to disk.
+func syncDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
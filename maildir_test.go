@@ -1,15 +1,19 @@
 package maildir
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // cleanup removes a Dir's directory structure
@@ -285,6 +289,60 @@ func TestCopy(t *testing.T) {
 	}
 }
 
+// brokenFS is a FS where reading files in cur fails.
+type brokenFS struct {
+	*MemFS
+}
+
+func (fsys brokenFS) Open(name string) (File, error) {
+	f, err := fsys.MemFS.Open(name)
+	if err != nil || filepath.Base(filepath.Dir(name)) != "cur" {
+		return f, err
+	}
+	return brokenFile{f}, nil
+}
+
+type brokenFile struct {
+	File
+}
+
+func (f brokenFile) Read(p []byte) (int, error) {
+	return 0, errors.New("I/O error")
+}
+
+func TestMessage_CopyTo_failure(t *testing.T) {
+	t.Parallel()
+
+	fsys := brokenFS{NewMemFS()}
+	src := FSDir{FS: fsys, Path: "src"}
+	dst := FSDir{FS: fsys, Path: "dst"}
+	for _, d := range []FSDir{src, dst} {
+		if err := d.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, w, err := src.Create([]Flag{FlagSeen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "this is a message")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := msg.CopyTo(Dir(dst.Path)); err == nil {
+		t.Fatal("CopyTo() succeeded")
+	}
+	for _, sub := range []string{"tmp", "cur"} {
+		if entries, err := fsys.ReadDir(filepath.Join("dst", sub)); err != nil {
+			t.Fatal(err)
+		} else if len(entries) != 0 {
+			t.Errorf("%v contains %v files after failed copy, want none", sub, len(entries))
+		}
+	}
+}
+
 func TestIllegal(t *testing.T) {
 	t.Parallel()
 	var d1 Dir = "test_illegal"
@@ -418,12 +476,25 @@ func TestDifferentSizesOfReaddirChunks(t *testing.T) {
 }
 
 func BenchmarkFilename(b *testing.B) {
+	benchmarkFilename(b, false)
+}
+
+func BenchmarkFilenameIndex(b *testing.B) {
+	benchmarkFilename(b, true)
+}
+
+func benchmarkFilename(b *testing.B, index bool) {
 	// set up test maildir
 	d := Dir("benchmark_filename")
 	if err := d.Init(); err != nil {
 		b.Fatalf("could not set up benchmark: %v", err)
 	}
 	defer cleanup(b, d)
+	if index {
+		if err := d.EnableIndex(); err != nil {
+			b.Fatalf("could not set up benchmark: %v", err)
+		}
+	}
 
 	// make 5000 deliveries
 	for i := 0; i < 5000; i++ {
@@ -478,3 +549,614 @@ func BenchmarkFilename(b *testing.B) {
 		}
 	}
 }
+
+func TestSizeAttributes(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	const text = "Subject: hi\n\nhello\r\n"
+	del, err := NewDeliveryWithOptions(string(d), &DeliveryOptions{SizeAttributes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, w, err := d.CreateWithOptions(nil, &DeliveryOptions{SizeAttributes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(msg.Key(), ",S=20,W=22") {
+		t.Errorf("Key() = %q, want S= and W= attributes", msg.Key())
+	}
+
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs = append(msgs, msg)
+	for _, msg := range msgs {
+		if size, err := msg.Size(); err != nil || size != int64(len(text)) {
+			t.Errorf("Size() = %v, %v, want %v", size, err, len(text))
+		}
+		if size, err := msg.VirtualSize(); err != nil || size != 22 {
+			t.Errorf("VirtualSize() = %v, %v, want 22", size, err)
+		}
+	}
+
+	// Without attributes, sizes are computed from the file
+	makeDelivery(t, d, text)
+	msgs, err = d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := msgs[0].Size(); err != nil || size != int64(len(text)) {
+		t.Errorf("Size() = %v, %v, want %v", size, err, len(text))
+	}
+	if size, err := msgs[0].VirtualSize(); err != nil || size != 22 {
+		t.Errorf("VirtualSize() = %v, %v, want 22", size, err)
+	}
+}
+
+func TestDelivery_Sync(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	del, err := NewDeliveryWithOptions(string(d), &DeliveryOptions{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, "durable"); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.UnseenCount(); err != nil || n != 1 {
+		t.Errorf("UnseenCount() = %v, %v, want 1", n, err)
+	}
+
+	// Make the rename fail
+	del, err = NewDeliveryWithOptions(string(d), &DeliveryOptions{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(string(d), "new")); err != nil {
+		t.Fatal(err)
+	}
+	var deliveryErr *DeliveryError
+	if err := del.Close(); !errors.As(err, &deliveryErr) || deliveryErr.Step != DeliveryStepRename {
+		t.Errorf("Close() = %v, want *DeliveryError at rename step", err)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Close() = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDelivery_Link(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	del, err := NewDeliveryWithOptions(string(d), &DeliveryOptions{Link: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, "linked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Occupy the destination filename
+	key := del.key
+	taken := filepath.Join(string(d), "new", key)
+	if err := os.WriteFile(taken, []byte("existing"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if del.key == key {
+		t.Errorf("key wasn't changed on collision")
+	}
+	if cat(t, taken) != "existing" {
+		t.Errorf("existing message was overwritten")
+	}
+	if cat(t, filepath.Join(string(d), "new", del.key)) != "linked" {
+		t.Errorf("Content doesn't match")
+	}
+	if entries, err := os.ReadDir(filepath.Join(string(d), "tmp")); err != nil || len(entries) != 0 {
+		t.Errorf("tmp contains %v files, want 0", len(entries))
+	}
+}
+
+func TestDir_Context(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "this is a message")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.UnseenCountContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("UnseenCountContext() = %v, want context.Canceled", err)
+	}
+	if msgs, err := d.UnseenContext(ctx); !errors.Is(err, context.Canceled) || len(msgs) != 0 {
+		t.Errorf("UnseenContext() = %v, %v, want context.Canceled", msgs, err)
+	}
+	if err := d.WalkContext(ctx, func(*Message) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("WalkContext() = %v, want context.Canceled", err)
+	}
+	if _, err := d.MessageByKeyContext(ctx, "missing"); !errors.Is(err, context.Canceled) {
+		t.Errorf("MessageByKeyContext() = %v, want context.Canceled", err)
+	}
+	if err := d.CleanContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("CleanContext() = %v, want context.Canceled", err)
+	}
+
+	if n, err := d.UnseenCountContext(context.Background()); err != nil || n != 1 {
+		t.Errorf("UnseenCountContext() = %v, %v, want 1", n, err)
+	}
+}
+
+func TestFSDir_CleanWithOptions(t *testing.T) {
+	t.Parallel()
+
+	fsys := NewMemFS()
+	d := FSDir{FS: fsys, Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		f, err := fsys.OpenFile(filepath.Join("mail", "tmp", name), os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, "hello")
+		f.Close()
+	}
+	// A non-empty directory cannot be removed
+	if err := fsys.Mkdir(filepath.Join("mail", "tmp", "0"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Mkdir(filepath.Join("mail", "tmp", "0", "child"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	later := func() time.Time { return time.Now().Add(2 * time.Hour) }
+	report, err := d.CleanWithOptions(context.Background(), &CleanOptions{MaxAge: time.Hour, Now: later, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 3 {
+		t.Errorf("dry-run report has %v files, want 3", len(report.Files))
+	}
+	if _, err := fsys.Stat(filepath.Join("mail", "tmp", "a")); err != nil {
+		t.Errorf("Stat() after dry-run = %v", err)
+	}
+
+	if _, err := d.CleanWithOptions(context.Background(), &CleanOptions{MaxAge: time.Hour}); err != nil {
+		t.Errorf("CleanWithOptions() with recent files = %v", err)
+	}
+
+	report, err = d.CleanWithOptions(context.Background(), &CleanOptions{MaxAge: time.Hour, Now: later})
+	if err == nil {
+		t.Error("CleanWithOptions() on non-empty directory succeeded")
+	}
+	if len(report.Files) != 0 {
+		t.Errorf("report has %v files after failure, want 0", len(report.Files))
+	}
+
+	report, err = d.CleanWithOptions(context.Background(), &CleanOptions{MaxAge: time.Hour, Now: later, ContinueOnError: true})
+	if err == nil {
+		t.Error("CleanWithOptions() on non-empty directory succeeded")
+	}
+	if len(report.Files) != 2 || report.Size != 10 {
+		t.Errorf("report = %v files and %v bytes, want 2 files and 10 bytes", len(report.Files), report.Size)
+	}
+	if _, err := fsys.Stat(filepath.Join("mail", "tmp", "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after clean = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDir_WalkNew(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "this is a message")
+
+	var msgs []*Message
+	err := d.WalkNew(func(msg *Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("WalkNew() yielded %v messages, want 1", len(msgs))
+	}
+	if subdir := msgs[0].Subdir(); subdir != "new" {
+		t.Errorf("Subdir() = %q, want %q", subdir, "new")
+	}
+	if cat(t, msgs[0].Filename()) != "this is a message" {
+		t.Error("Content doesn't match")
+	}
+
+	if n, err := d.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("UnseenCount() = %v after WalkNew(), want 1", n)
+	}
+
+	unseen, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unseen[0].Key() != msgs[0].Key() {
+		t.Errorf("Key() = %q in cur, want %q", unseen[0].Key(), msgs[0].Key())
+	}
+	if subdir := unseen[0].Subdir(); subdir != "cur" {
+		t.Errorf("Subdir() = %q, want %q", subdir, "cur")
+	}
+}
+
+func TestDir_UnseenWithOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		policy InfoPolicy
+		info   string
+		want   string
+	}{
+		{InfoDiscard, "2,RS", "2,F"},
+		{InfoKeep, "2,RS", "2,RS"},
+		{InfoKeep, "1,experimental", "2,F"},
+		{InfoMerge, "2,RS", "2,FRS"},
+		{InfoMerge, "", "2,F"},
+	} {
+		d := Dir(t.TempDir())
+		if err := d.Init(); err != nil {
+			t.Fatal(err)
+		}
+		basename := "1700000000.M1.example"
+		if tc.info != "" {
+			basename += string(separator) + tc.info
+		}
+		if err := os.WriteFile(filepath.Join(string(d), "new", basename), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		options := &UnseenOptions{Info: tc.policy, Flags: []Flag{FlagFlagged}}
+		msgs, err := d.UnseenWithOptions(context.Background(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "1700000000.M1.example" + string(separator) + tc.want
+		if len(msgs) != 1 || filepath.Base(msgs[0].Filename()) != want {
+			t.Errorf("UnseenWithOptions(%v) with info %q = %v, want %q", tc.policy, tc.info, msgs, want)
+		}
+	}
+}
+
+// racyFS is a FS where some files vanish right before being renamed.
+type racyFS struct {
+	*MemFS
+	vanish map[string]bool // by basename
+}
+
+func (fsys *racyFS) Rename(oldpath, newpath string) error {
+	if fsys.vanish[filepath.Base(oldpath)] {
+		fsys.MemFS.Remove(oldpath)
+	}
+	return fsys.MemFS.Rename(oldpath, newpath)
+}
+
+func TestFSDir_Unseen_partial(t *testing.T) {
+	t.Parallel()
+
+	fsys := &racyFS{MemFS: NewMemFS(), vanish: map[string]bool{"vanished": true}}
+	d := FSDir{FS: fsys, Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.a", "vanished", string(separator) + "2,S", "2.b"} {
+		f, err := fsys.OpenFile(filepath.Join("mail", "new", name), os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	msgs, err := d.Unseen()
+	if len(msgs) != 2 {
+		t.Errorf("Unseen() returned %v messages, want 2", len(msgs))
+	}
+	var mailfileErr *MailfileError
+	if !errors.As(err, &mailfileErr) {
+		t.Errorf("Unseen() error = %v, want a *MailfileError", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Unseen() error = %v, want ErrNotExist", err)
+	}
+	if n, err := d.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("UnseenCount() = %v, want 1", n)
+	}
+}
+
+func TestDir_Create_abort(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, w, err := d.Create([]Flag{FlagSeen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Key() != msg.Key() {
+		t.Errorf("Key() = %q, want %q", w.Key(), msg.Key())
+	}
+	if _, err := io.WriteString(w, "this is a partial message"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(string(d), sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%v contains %v files after Abort(), want none", sub, len(entries))
+		}
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close() after Abort() succeeded")
+	}
+}
+
+func TestDir_CreateWithOptions_invalid(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := d.CreateWithOptions(nil, &DeliveryOptions{Subdir: "new"}); err == nil {
+		t.Error("CreateWithOptions() into new succeeded")
+	}
+	if _, _, err := d.CreateWithOptions(nil, &DeliveryOptions{Flags: []Flag{FlagSeen}}); err == nil {
+		t.Error("CreateWithOptions() with flags in options succeeded")
+	}
+	if entries, err := os.ReadDir(filepath.Join(string(d), "tmp")); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("tmp contains %v files after rejected creations, want none", len(entries))
+	}
+
+	_, w, err := d.CreateWithOptions(nil, &DeliveryOptions{Subdir: "cur"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDelivery_Cur(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.EnableIndex(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.DisableIndex()
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	options := &DeliveryOptions{
+		Subdir:  "cur",
+		Flags:   []Flag{FlagSeen, FlagDraft},
+		ModTime: mtime,
+		Link:    true,
+	}
+	del, err := NewDeliveryWithOptions(string(d), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, "this is a message"); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := d.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("UnseenCount() = %v, want 0", n)
+	}
+	msg, err := d.MessageByKey(del.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := msg.Flags(); len(flags) != 2 || flags[0] != FlagDraft || flags[1] != FlagSeen {
+		t.Errorf("Flags() = %v, want {FlagDraft, FlagSeen}", flags)
+	}
+	fi, err := os.Stat(msg.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("ModTime() = %v, want %v", fi.ModTime(), mtime)
+	}
+
+	if _, err := NewDeliveryWithOptions(string(d), &DeliveryOptions{Flags: []Flag{FlagSeen}}); err == nil {
+		t.Error("NewDeliveryWithOptions() with flags in new succeeded")
+	}
+	if _, err := NewDeliveryWithOptions(string(d), &DeliveryOptions{Subdir: "tmp"}); err == nil {
+		t.Error("NewDeliveryWithOptions() into tmp succeeded")
+	}
+}
+
+func TestMessage_MoveTo_new(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	target := Dir(t.TempDir())
+	for _, d := range []Dir{d, target} {
+		if err := d.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makeDelivery(t, d, "this is a message")
+
+	var msgs []*Message
+	err := d.WalkNew(func(msg *Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msgs[0].MoveTo(target); err != nil {
+		t.Fatal(err)
+	}
+	if subdir := msgs[0].Subdir(); subdir != "cur" {
+		t.Errorf("Subdir() = %q after MoveTo(), want %q", subdir, "cur")
+	}
+
+	moved, err := target.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 1 || moved[0].Key() != msgs[0].Key() {
+		t.Errorf("Messages() in target = %v, want key %q", moved, msgs[0].Key())
+	}
+}
+
+func TestMessageWriter_Abort_afterClose(t *testing.T) {
+	t.Parallel()
+
+	fsys := NewMemFS()
+	d := FSDir{FS: fsys, Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, w, err := d.Create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "this is a message"); err != nil {
+		t.Fatal(err)
+	}
+	// Publishing fails without cur
+	if err := fsys.Remove(filepath.Join("mail", "cur")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Close() without cur succeeded")
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() after failed Close() = %v", err)
+	}
+	if entries, err := fsys.ReadDir(filepath.Join("mail", "tmp")); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("tmp contains %v files after Abort(), want none", len(entries))
+	}
+}
+
+// syncErrorFS is a FS where flushing files to disk fails.
+type syncErrorFS struct {
+	*MemFS
+}
+
+func (fsys syncErrorFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := fsys.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return syncErrorFile{f}, nil
+}
+
+type syncErrorFile struct {
+	File
+}
+
+func (f syncErrorFile) Sync() error {
+	return errors.New("I/O error")
+}
+
+func TestDelivery_Abort_afterClose(t *testing.T) {
+	t.Parallel()
+
+	fsys := syncErrorFS{NewMemFS()}
+	d := FSDir{FS: fsys, Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	del, err := d.NewDelivery(&DeliveryOptions{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, "this is a message"); err != nil {
+		t.Fatal(err)
+	}
+	var deliveryErr *DeliveryError
+	if err := del.Close(); !errors.As(err, &deliveryErr) || deliveryErr.Step != DeliveryStepSyncFile {
+		t.Fatalf("Close() = %v, want a *DeliveryError at %v", err, DeliveryStepSyncFile)
+	}
+	if err := del.Abort(); err != nil {
+		t.Fatalf("Abort() after failed Close() = %v", err)
+	}
+	if entries, err := fsys.ReadDir(filepath.Join("mail", "tmp")); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("tmp contains %v files after Abort(), want none", len(entries))
+	}
+}
@@ -6,3 +6,9 @@ package maildir
 // This should only be changed on operating systems where the colon isn't
 // allowed in filenames.
 const separator rune = ';'
+
+// syncDir flushes a directory to disk. This is a no-op on Windows, where
+// directories cannot be opened for flushing.
+func syncDir(path string) error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package maildirpp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-maildir"
+)
+
+// folderMarker is the name of the file created in each folder. It tells
+// delivery agents that the directory is a folder and not a root.
+const folderMarker = "maildirfolder"
+
+// A FolderError occurs when a folder operation targets an invalid folder.
+type FolderError struct {
+	Name []string // the folder name
+	Err  error    // the underlying error
+}
+
+func (e *FolderError) Error() string {
+	return fmt.Sprintf("maildirpp: folder %q: %v", strings.Join(e.Name, string(separator)), e.Err)
+}
+
+func (e *FolderError) Unwrap() error {
+	return e.Err
+}
+
+// Root is the top-level directory of a Maildir++ mailbox.
+//
+// The root directory is itself a maildir, usually exposed as INBOX. Each
+// folder is stored in a sub-directory of the root whose name is built with
+// JoinUTF7, e.g. ".Archive.2024". Folder names may contain any Unicode
+// character, including dots.
+type Root string
+
+// Inbox returns the maildir stored directly in the root.
+func (r Root) Inbox() maildir.Dir {
+	return maildir.Dir(r)
+}
+
+// Init creates the directory structure for the root maildir.
+func (r Root) Init() error {
+	return r.Inbox().Init()
+}
+
+func (r Root) folderPath(name []string) (string, error) {
+	if len(name) == 0 {
+		return "", &FolderError{name, errors.New("empty folder name")}
+	}
+	for _, elem := range name {
+		if elem == "" {
+			return "", &FolderError{name, errors.New("empty folder name element")}
+		}
+	}
+	key, err := JoinUTF7(name)
+	if err != nil {
+		return "", &FolderError{name, err}
+	}
+	if strings.ContainsRune(key, filepath.Separator) {
+		return "", &FolderError{name, errors.New("folder name cannot contain a path separator")}
+	}
+	return filepath.Join(string(r), key), nil
+}
+
+// Folder returns the maildir for the folder with the given name. It does not
+// check whether the folder exists.
+func (r Root) Folder(name []string) (maildir.Dir, error) {
+	path, err := r.folderPath(name)
+	if err != nil {
+		return "", err
+	}
+	return maildir.Dir(path), nil
+}
+
+// Folders returns the names of all folders in the root, sorted by key.
+//
+// Each folder name is returned as a list of hierarchy elements, as returned
+// by SplitUTF7. Directories whose name cannot be decoded are ignored.
+func (r Root) Folders() ([][]string, error) {
+	entries, err := os.ReadDir(string(r))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == "." || name == ".." || len(name) < 2 || name[0] != '.' {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	var folders [][]string
+	for _, key := range keys {
+		name, err := SplitUTF7(key)
+		if err != nil {
+			continue
+		}
+		folders = append(folders, name)
+	}
+	return folders, nil
+}
+
+// CreateFolder creates a new folder and returns its maildir.
+//
+// The folder directory structure is initialized and the maildirfolder marker
+// file is created. An error wrapping fs.ErrExist is returned if the folder
+// already exists.
+func (r Root) CreateFolder(name []string) (maildir.Dir, error) {
+	path, err := r.folderPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Mkdir(path, 0700); err != nil {
+		return "", err
+	}
+	d := maildir.Dir(path)
+	if err := d.Init(); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(filepath.Join(path, folderMarker), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return d, nil
+}
+
+// RenameFolder renames a folder.
+//
+// All sub-folders are renamed as well. If an error occurs while renaming one
+// of the sub-folders, this function may leave a partially renamed hierarchy.
+// If a folder named newName already exists, which includes renaming a folder
+// to itself, an error wrapping os.ErrExist is returned.
+func (r Root) RenameFolder(oldName, newName []string) error {
+	oldPath, err := r.folderPath(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := r.folderPath(newName)
+	if err != nil {
+		return err
+	}
+	if len(oldName) == len(newName) && isPrefix(oldName, newName) {
+		return &FolderError{newName, os.ErrExist}
+	} else if isPrefix(oldName, newName) {
+		return &FolderError{newName, errors.New("cannot rename a folder to one of its sub-folders")}
+	}
+
+	folders, err := r.Folders()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(newPath); err == nil {
+		return &FolderError{newName, os.ErrExist}
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	for _, name := range folders {
+		if len(name) <= len(oldName) || !isPrefix(oldName, name) {
+			continue
+		}
+		children := append(append([]string(nil), newName...), name[len(oldName):]...)
+		from, err := r.folderPath(name)
+		if err != nil {
+			return err
+		}
+		to, err := r.folderPath(children)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteFolder removes a folder and all of its messages.
+//
+// Sub-folders are left untouched.
+func (r Root) DeleteFolder(name []string) error {
+	path, err := r.folderPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// isPrefix reports whether prefix is a hierarchy prefix of name.
+func isPrefix(prefix, name []string) bool {
+	if len(prefix) > len(name) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != name[i] {
+			return false
+		}
+	}
+	return true
+}
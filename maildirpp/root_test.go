@@ -0,0 +1,62 @@
+package maildirpp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoot(t *testing.T) {
+	r := Root(t.TempDir())
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range [][]string{{"Archive"}, {"Archive", "2024"}, {"Sent"}, {"Entwürfe", "v1.2"}} {
+		if _, err := r.CreateFolder(name); err != nil {
+			t.Fatalf("CreateFolder(%v) = %v", name, err)
+		}
+	}
+
+	if _, err := r.CreateFolder([]string{"Sent"}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("CreateFolder() on existing folder = %v, want ErrExist", err)
+	}
+
+	d, err := r.Folder([]string{"Archive", "2024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), folderMarker)); err != nil {
+		t.Errorf("maildirfolder marker missing: %v", err)
+	}
+
+	folders, err := r.Folders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"Archive"}, {"Archive", "2024"}, {"Entwürfe", "v1.2"}, {"Sent"}}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("Folders() = %v, want %v", folders, want)
+	}
+
+	if err := r.RenameFolder([]string{"Archive"}, []string{"Archive"}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("RenameFolder() to the same name = %v, want ErrExist", err)
+	}
+	if err := r.RenameFolder([]string{"Archive"}, []string{"Old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteFolder([]string{"Sent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err = r.Folders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{{"Entwürfe", "v1.2"}, {"Old"}, {"Old", "2024"}}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("Folders() = %v, want %v", folders, want)
+	}
+}
@@ -0,0 +1,161 @@
+package maildirpp
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// utf7Encoding is the modified base64 alphabet used by IMAP mailbox names
+// (RFC 3501 section 5.1.3): "," is used instead of "/".
+var utf7Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+var errInvalidUTF7 = errors.New("maildirpp: invalid modified UTF-7")
+
+// utf7Direct reports whether r can be represented as itself in an encoded
+// folder name. Besides the characters which need to be encoded in IMAP
+// modified UTF-7, the hierarchy separator and the slash are always encoded,
+// so that they can appear in folder names.
+func utf7Direct(r rune) bool {
+	return r >= 0x20 && r <= 0x7e && r != '&' && r != separator && r != '/'
+}
+
+// EncodeUTF7 encodes a folder name element with IMAP modified UTF-7.
+//
+// Dots and slashes are encoded as well, so the result can always be used as
+// a Maildir++ key element.
+func EncodeUTF7(name string) string {
+	var sb strings.Builder
+	var shifted []rune
+	flush := func() {
+		if len(shifted) == 0 {
+			return
+		}
+		units := utf16.Encode(shifted)
+		b := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			b = append(b, byte(u>>8), byte(u))
+		}
+		sb.WriteByte('&')
+		sb.WriteString(utf7Encoding.EncodeToString(b))
+		sb.WriteByte('-')
+		shifted = shifted[:0]
+	}
+
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			sb.WriteString("&-")
+		case utf7Direct(r):
+			flush()
+			sb.WriteRune(r)
+		default:
+			shifted = append(shifted, r)
+		}
+	}
+	flush()
+
+	return sb.String()
+}
+
+// equalUTF16 reports whether two UTF-16 strings are equal.
+func equalUTF16(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeUTF7 decodes a folder name element encoded with IMAP modified UTF-7.
+// Only the canonical encoding of a name, as produced by EncodeUTF7, is
+// accepted.
+func DecodeUTF7(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '&' {
+			if c < 0x20 || c > 0x7e {
+				return "", errInvalidUTF7
+			}
+			sb.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(s[i+1:], '-')
+		if end < 0 {
+			return "", errInvalidUTF7
+		}
+		encoded := s[i+1 : i+1+end]
+		i += end + 1
+		if encoded == "" {
+			sb.WriteByte('&')
+			continue
+		}
+
+		b, err := utf7Encoding.DecodeString(encoded)
+		if err != nil || len(b)%2 != 0 {
+			return "", errInvalidUTF7
+		}
+		units := make([]uint16, len(b)/2)
+		for j := range units {
+			units[j] = uint16(b[2*j])<<8 | uint16(b[2*j+1])
+		}
+		runes := utf16.Decode(units)
+		if !equalUTF16(utf16.Encode(runes), units) {
+			// Unpaired surrogates are decoded as U+FFFD, which is encoded
+			// differently
+			return "", errInvalidUTF7
+		}
+		for _, r := range runes {
+			if utf7Direct(r) {
+				// Characters which should have been represented as
+				// themselves are rejected
+				return "", errInvalidUTF7
+			}
+			sb.WriteRune(r)
+		}
+	}
+
+	// Reject non-canonical encodings, such as two adjacent shifted sequences
+	// or non-zero padding bits, so that each name has a single encoding
+	decoded := sb.String()
+	if EncodeUTF7(decoded) != s {
+		return "", errInvalidUTF7
+	}
+	return decoded, nil
+}
+
+// SplitUTF7 is like Split, but decodes each element with DecodeUTF7.
+func SplitUTF7(key string) ([]string, error) {
+	elems, err := Split(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, elem := range elems {
+		if elems[i], err = DecodeUTF7(elem); err != nil {
+			return nil, err
+		}
+	}
+	return elems, nil
+}
+
+// JoinUTF7 is like Join, but encodes each element with EncodeUTF7. Unlike
+// Join, it accepts elements containing dots.
+func JoinUTF7(elems []string) (key string, err error) {
+	encoded := make([]string, len(elems))
+	for i, elem := range elems {
+		if !utf8.ValidString(elem) {
+			return "", errors.New("maildirpp: directory name is not valid UTF-8")
+		}
+		encoded[i] = EncodeUTF7(elem)
+	}
+	return Join(encoded)
+}
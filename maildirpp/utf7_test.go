@@ -0,0 +1,64 @@
+package maildirpp
+
+import (
+	"reflect"
+	"testing"
+)
+
+var utf7Tests = []struct {
+	decoded string
+	encoded string
+}{
+	{"INBOX", "INBOX"},
+	{"Entwürfe", "Entw&APw-rfe"},
+	{"ä", "&AOQ-"},
+	{"Tom & Jerry", "Tom &- Jerry"},
+	{"v1.2/old", "v1&AC4-2&AC8-old"},
+	{"日本語", "&ZeVnLIqe-"},
+	{"😀", "&2D3eAA-"},
+	{"a\uFFFDb", "a&,,0-b"},
+}
+
+func TestEncodeUTF7(t *testing.T) {
+	for _, tc := range utf7Tests {
+		if got := EncodeUTF7(tc.decoded); got != tc.encoded {
+			t.Errorf("EncodeUTF7(%q) = %q, want %q", tc.decoded, got, tc.encoded)
+		}
+	}
+}
+
+func TestDecodeUTF7(t *testing.T) {
+	for _, tc := range utf7Tests {
+		got, err := DecodeUTF7(tc.encoded)
+		if err != nil {
+			t.Errorf("DecodeUTF7(%q) = %v", tc.encoded, err)
+		} else if got != tc.decoded {
+			t.Errorf("DecodeUTF7(%q) = %q, want %q", tc.encoded, got, tc.decoded)
+		}
+	}
+
+	for _, s := range []string{"&AOQ", "&AGE-", "&2D3-", "&A-", "&AOQ-&AOQ-", "&AOR-"} {
+		if _, err := DecodeUTF7(s); err == nil {
+			t.Errorf("DecodeUTF7(%q) = nil, want an error", s)
+		}
+	}
+}
+
+func TestJoinSplitUTF7(t *testing.T) {
+	elems := []string{"Archiv", "v1.2", "Entwürfe"}
+	key, err := JoinUTF7(elems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ".Archiv.v1&AC4-2.Entw&APw-rfe"; key != want {
+		t.Errorf("JoinUTF7() = %q, want %q", key, want)
+	}
+
+	got, err := SplitUTF7(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, elems) {
+		t.Errorf("SplitUTF7(%q) = %q, want %q", key, got, elems)
+	}
+}
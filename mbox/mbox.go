@@ -0,0 +1,365 @@
+// Package mbox imports and exports maildirs from and to mbox files.
+//
+// The mboxo, mboxrd and mboxcl2 variants are supported. Message flags are
+// mapped to and from the Status and X-Status header fields.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-maildir"
+)
+
+var errInvalidFormat = errors.New("mbox: invalid format")
+
+// Format is an mbox variant.
+type Format int
+
+const (
+	// Lines starting with "From " are quoted with ">". Quoting is ambiguous:
+	// lines starting with ">From " are unquoted as well.
+	FormatMboxo Format = iota
+	// Lines starting with any number of ">" followed by "From " are quoted
+	// with an additional ">".
+	FormatMboxrd
+	// Messages are delimited with a Content-Length header field and lines
+	// are not quoted.
+	FormatMboxcl2
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatMboxo:
+		return "mboxo"
+	case FormatMboxrd:
+		return "mboxrd"
+	case FormatMboxcl2:
+		return "mboxcl2"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// quotedFromLevel returns the number of ">" before "From " at the start of a
+// line, or -1 if the line doesn't match.
+func quotedFromLevel(line []byte) int {
+	n := 0
+	for n < len(line) && line[n] == '>' {
+		n++
+	}
+	if !bytes.HasPrefix(line[n:], []byte("From ")) {
+		return -1
+	}
+	return n
+}
+
+func (f Format) quote(line []byte) []byte {
+	level := quotedFromLevel(line)
+	if level == 0 || (f == FormatMboxrd && level > 0) {
+		return append([]byte(">"), line...)
+	}
+	return line
+}
+
+func (f Format) unquote(line []byte) []byte {
+	level := quotedFromLevel(line)
+	if level == 1 || (f == FormatMboxrd && level > 1) {
+		return line[1:]
+	}
+	return line
+}
+
+// statusFlags maps Status and X-Status letters to maildir flags.
+var statusFlags = []struct {
+	field  string
+	letter byte
+	flag   maildir.Flag
+}{
+	{"Status", 'R', maildir.FlagSeen},
+	{"X-Status", 'A', maildir.FlagReplied},
+	{"X-Status", 'F', maildir.FlagFlagged},
+	{"X-Status", 'T', maildir.FlagDraft},
+	{"X-Status", 'D', maildir.FlagTrashed},
+}
+
+// headerFieldName returns the canonical name of the header field starting on
+// line, or an empty string if the line is a continuation line.
+func headerFieldName(line []byte) string {
+	if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		return ""
+	}
+	k, _, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(string(k))
+}
+
+// readHeader reads the raw header lines of a message, up to and including
+// the blank line. Continuation lines are appended to their field. The blank
+// line is returned as is, it is nil if the message has no body.
+func readHeader(br *bufio.Reader) (fields [][]byte, blank []byte, n int64, err error) {
+	for {
+		line, err := br.ReadBytes('\n')
+		n += int64(len(line))
+		if len(line) == 0 && err == io.EOF {
+			return fields, nil, n, nil
+		} else if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return fields, line, n, nil
+		}
+		if len(fields) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[len(fields)-1] = append(fields[len(fields)-1], line...)
+		} else {
+			fields = append(fields, line)
+		}
+		if err == io.EOF {
+			return fields, nil, n, nil
+		} else if err != nil {
+			return nil, nil, n, err
+		}
+	}
+}
+
+// lineEnding returns the line ending used by a message header, "\r\n" or
+// "\n".
+func lineEnding(fields [][]byte, blank []byte) string {
+	line := blank
+	if line == nil && len(fields) > 0 {
+		line = fields[0]
+	}
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// Import reads messages from an mbox file and inserts them into a Maildir.
+// It returns the number of imported messages.
+//
+// The Status and X-Status header fields are converted to flags and removed
+// from the messages. With FormatMboxcl2, the Content-Length header field is
+// removed as well.
+func Import(d maildir.Dir, r io.Reader, format Format) (int, error) {
+	mr := newReader(r, format)
+	n := 0
+	for {
+		msg, err := mr.next()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if err := importMessage(d, msg, format); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func importMessage(d maildir.Dir, r io.Reader, format Format) error {
+	br := bufio.NewReader(r)
+	fields, blank, _, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+
+	var flags []maildir.Flag
+	var kept [][]byte
+	for _, field := range fields {
+		name := headerFieldName(field)
+		if format == FormatMboxcl2 && strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+		if !strings.EqualFold(name, "Status") && !strings.EqualFold(name, "X-Status") {
+			kept = append(kept, field)
+			continue
+		}
+		_, value, _ := bytes.Cut(field, []byte(":"))
+		for _, sf := range statusFlags {
+			if strings.EqualFold(name, sf.field) && bytes.IndexByte(value, sf.letter) >= 0 {
+				flags = append(flags, sf.flag)
+			}
+		}
+	}
+
+	_, w, err := d.Create(flags)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, field := range kept {
+		bw.Write(field)
+	}
+	if blank != nil {
+		bw.Write(blank)
+	} else if len(fields) > 0 {
+		bw.WriteString(lineEnding(fields, blank))
+	}
+	_, err = io.Copy(bw, br)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// Export writes all messages of a Maildir to an mbox file, sorted by key. It
+// returns the number of exported messages.
+//
+// Only messages in cur are exported, Dir.Unseen can be used beforehand to
+// include new messages. Status and X-Status header fields are generated
+// from the message flags.
+func Export(w io.Writer, d maildir.Dir, format Format) (int, error) {
+	msgs, err := d.Messages()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Key() < msgs[j].Key()
+	})
+
+	bw := bufio.NewWriter(w)
+	for i, msg := range msgs {
+		if err := exportMessage(bw, msg, format); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), bw.Flush()
+}
+
+func exportMessage(bw *bufio.Writer, msg *maildir.Message, format Format) error {
+	rc, err := msg.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Files opened by a maildir.FS have a Stat method
+	f, ok := rc.(fs.File)
+	if !ok {
+		return fmt.Errorf("mbox: cannot stat message %q", msg.Key())
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(f)
+	fields, blank, headerSize, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	eol := lineEnding(fields, blank)
+
+	var kept [][]byte
+	for _, field := range fields {
+		switch strings.ToLower(headerFieldName(field)) {
+		case "status", "x-status":
+			continue
+		case "content-length":
+			if format == FormatMboxcl2 {
+				continue
+			}
+		}
+		kept = append(kept, field)
+	}
+
+	var status, xStatus strings.Builder
+	for _, sf := range statusFlags {
+		for _, flag := range msg.Flags() {
+			if flag != sf.flag {
+				continue
+			}
+			if sf.field == "Status" {
+				status.WriteByte(sf.letter)
+			} else {
+				xStatus.WriteByte(sf.letter)
+			}
+		}
+	}
+	status.WriteByte('O')
+	kept = append(kept, []byte("Status: "+status.String()+eol))
+	if xStatus.Len() > 0 {
+		kept = append(kept, []byte("X-Status: "+xStatus.String()+eol))
+	}
+	if format == FormatMboxcl2 {
+		kept = append(kept, []byte(fmt.Sprintf("Content-Length: %d%s", fi.Size()-headerSize, eol)))
+	}
+
+	sender, date := envelope(fields, fi.ModTime())
+	fmt.Fprintf(bw, "From %s %s%s", sender, date.UTC().Format(time.ANSIC), eol)
+
+	last := byte('\n')
+	writeLine := func(line []byte) {
+		if format != FormatMboxcl2 {
+			line = format.quote(line)
+		}
+		bw.Write(line)
+		last = line[len(line)-1]
+	}
+	for _, field := range kept {
+		writeLine(field)
+	}
+	if blank != nil {
+		bw.Write(blank)
+	} else {
+		bw.WriteString(eol)
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			writeLine(line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if last != '\n' {
+		bw.WriteString(eol)
+	}
+	_, err = bw.WriteString(eol)
+	return err
+}
+
+// envelope returns the sender address and date of the From line.
+func envelope(fields [][]byte, modTime time.Time) (sender string, date time.Time) {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		buf.Write(field)
+	}
+	buf.WriteString("\n")
+
+	sender = "MAILER-DAEMON"
+	date = modTime
+	m, err := mail.ReadMessage(&buf)
+	if err != nil {
+		return sender, date
+	}
+	if t, err := m.Header.Date(); err == nil {
+		date = t
+	}
+	for _, k := range []string{"Return-Path", "Sender", "From"} {
+		addr, err := mail.ParseAddress(m.Header.Get(k))
+		if err == nil && addr.Address != "" && !strings.ContainsAny(addr.Address, " \t") {
+			sender = addr.Address
+			break
+		}
+	}
+	return sender, date
+}
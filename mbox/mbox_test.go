@@ -0,0 +1,209 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-maildir"
+)
+
+const testMboxrd = `From alice@example.org Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.org>
+Subject: first
+Status: RO
+X-Status: AF
+
+Hello
+>From the start
+>>From quoted
+
+From bob@example.org Mon Jan  2 15:04:06 2006
+From: Bob <bob@example.org>
+Subject: second
+
+Bye
+`
+
+func readMessages(t *testing.T, d maildir.Dir) ([]*maildir.Message, []string) {
+	msgs, err := d.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Key() < msgs[j].Key()
+	})
+	var contents []string
+	for _, msg := range msgs {
+		b, err := os.ReadFile(msg.Filename())
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	return msgs, contents
+}
+
+func TestImport(t *testing.T) {
+	d := maildir.Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Import(d, strings.NewReader(testMboxrd), FormatMboxrd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Import() = %v, want 2", n)
+	}
+
+	msgs, contents := readMessages(t, d)
+	var first, second int
+	if strings.Contains(contents[0], "second") {
+		first, second = 1, 0
+	} else {
+		first, second = 0, 1
+	}
+
+	want := "From: Alice <alice@example.org>\nSubject: first\n\nHello\nFrom the start\n>From quoted\n"
+	if contents[first] != want {
+		t.Errorf("first message = %q, want %q", contents[first], want)
+	}
+	if flags := string(msgs[first].Flags()); flags != "FRS" {
+		t.Errorf("first message flags = %q, want FRS", flags)
+	}
+
+	want = "From: Bob <bob@example.org>\nSubject: second\n\nBye\n"
+	if contents[second] != want {
+		t.Errorf("second message = %q, want %q", contents[second], want)
+	}
+	if flags := msgs[second].Flags(); len(flags) != 0 {
+		t.Errorf("second message flags = %q, want none", flags)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatMboxo, FormatMboxrd, FormatMboxcl2} {
+		t.Run(format.String(), func(t *testing.T) {
+			src := maildir.Dir(t.TempDir())
+			if err := src.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Import(src, strings.NewReader(testMboxrd), FormatMboxrd); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if n, err := Export(&buf, src, format); err != nil {
+				t.Fatal(err)
+			} else if n != 2 {
+				t.Fatalf("Export() = %v, want 2", n)
+			}
+			if format == FormatMboxcl2 && !strings.Contains(buf.String(), "\nFrom the start\n") {
+				t.Errorf("mboxcl2 export is quoted:\n%v", buf.String())
+			}
+
+			dst := maildir.Dir(t.TempDir())
+			if err := dst.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := Import(dst, &buf, format); err != nil {
+				t.Fatal(err)
+			} else if n != 2 {
+				t.Fatalf("Import() = %v, want 2", n)
+			}
+
+			srcMsgs, srcContents := readMessages(t, src)
+			dstMsgs, dstContents := readMessages(t, dst)
+			sort.Strings(srcContents)
+			sort.Strings(dstContents)
+			for i := range srcContents {
+				want := srcContents[i]
+				if format == FormatMboxo {
+					// mboxo quoting is ambiguous
+					want = strings.Replace(want, ">From quoted", "From quoted", 1)
+				}
+				if dstContents[i] != want {
+					t.Errorf("message = %q, want %q", dstContents[i], want)
+				}
+			}
+			seen := 0
+			for _, msgs := range [][]*maildir.Message{srcMsgs, dstMsgs} {
+				for _, msg := range msgs {
+					if string(msg.Flags()) == "FRS" {
+						seen++
+					}
+				}
+			}
+			if seen != 2 {
+				t.Errorf("flags were not preserved")
+			}
+		})
+	}
+}
+
+func TestImport_invalid(t *testing.T) {
+	d := maildir.Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(d, strings.NewReader("not an mbox\n"), FormatMboxrd); err == nil || err == io.EOF {
+		t.Errorf("Import() = %v, want an error", err)
+	}
+}
+
+func TestRoundTrip_crlf(t *testing.T) {
+	mbox := strings.ReplaceAll(testMboxrd, "\n", "\r\n")
+	for _, format := range []Format{FormatMboxo, FormatMboxrd, FormatMboxcl2} {
+		t.Run(format.String(), func(t *testing.T) {
+			src := maildir.Dir(t.TempDir())
+			if err := src.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Import(src, strings.NewReader(mbox), FormatMboxrd); err != nil {
+				t.Fatal(err)
+			}
+			_, srcContents := readMessages(t, src)
+			for _, s := range srcContents {
+				if strings.Count(s, "\n") != strings.Count(s, "\r\n") {
+					t.Errorf("imported message has mixed line endings: %q", s)
+				}
+			}
+
+			var buf bytes.Buffer
+			if _, err := Export(&buf, src, format); err != nil {
+				t.Fatal(err)
+			}
+			if out := buf.String(); strings.Count(out, "\n") != strings.Count(out, "\r\n") {
+				t.Errorf("exported mbox has mixed line endings: %q", out)
+			}
+
+			dst := maildir.Dir(t.TempDir())
+			if err := dst.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := Import(dst, &buf, format); err != nil {
+				t.Fatal(err)
+			} else if n != 2 {
+				t.Fatalf("Import() = %v, want 2", n)
+			}
+
+			_, dstContents := readMessages(t, dst)
+			sort.Strings(srcContents)
+			sort.Strings(dstContents)
+			for i := range srcContents {
+				want := srcContents[i]
+				if format == FormatMboxo {
+					want = strings.Replace(want, ">From quoted", "From quoted", 1)
+				}
+				if dstContents[i] != want {
+					t.Errorf("message = %q, want %q", dstContents[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// reader splits an mbox file into messages.
+type reader struct {
+	br     *bufio.Reader
+	format Format
+	cur    io.Reader // the current message
+	done   bool      // the current message has been read entirely
+	start  bool      // the first From line has been read
+}
+
+func newReader(r io.Reader, format Format) *reader {
+	return &reader{br: bufio.NewReader(r), format: format, done: true}
+}
+
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) > 0
+}
+
+// skipToFrom discards lines until the next From line. It returns io.EOF if
+// there are no more messages.
+func (r *reader) skipToFrom() error {
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if isFromLine(line) {
+			return nil
+		} else if len(line) > 0 && !isBlankLine(line) {
+			return errInvalidFormat
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// next returns the next message. It returns io.EOF if there are no more
+// messages. The returned reader is only valid until the next call.
+func (r *reader) next() (io.Reader, error) {
+	if !r.start {
+		r.start = true
+		if err := r.skipToFrom(); err != nil {
+			return nil, err
+		}
+	} else if !r.done {
+		// Discard the rest of the current message
+		if _, err := io.Copy(io.Discard, r.cur); err != nil {
+			return nil, err
+		}
+	}
+	if r.br == nil {
+		return nil, io.EOF
+	}
+
+	r.done = false
+	if r.format == FormatMboxcl2 {
+		msg, err := r.nextContentLength()
+		if err != nil {
+			return nil, err
+		}
+		r.cur = msg
+	} else {
+		r.cur = &messageReader{r: r, unquote: r.format.unquote}
+	}
+	return r.cur, nil
+}
+
+// end marks the current message as read. If eof is true, there are no more
+// messages.
+func (r *reader) end(eof bool) {
+	r.done = true
+	if eof {
+		r.br = nil
+	}
+}
+
+// nextContentLength reads the header of a message and returns a reader for
+// the message delimited by its Content-Length header field.
+func (r *reader) nextContentLength() (io.Reader, error) {
+	var header bytes.Buffer
+	length := int64(-1)
+	for {
+		line, err := r.br.ReadBytes('\n')
+		header.Write(line)
+		if isBlankLine(line) {
+			break
+		} else if err == io.EOF {
+			r.end(true)
+			return &header, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		k, v, ok := bytes.Cut(line, []byte(":"))
+		if ok && textproto.CanonicalMIMEHeaderKey(string(k)) == "Content-Length" {
+			if n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64); err == nil && n >= 0 {
+				length = n
+			}
+		}
+	}
+
+	if length < 0 {
+		// No Content-Length, fall back to From lines without unquoting
+		return io.MultiReader(&header, &messageReader{r: r}), nil
+	}
+	return io.MultiReader(&header, &contentLengthReader{r: r, remaining: length}), nil
+}
+
+// contentLengthReader reads a message body delimited by its length.
+type contentLengthReader struct {
+	r         *reader
+	remaining int64
+}
+
+func (cr *contentLengthReader) Read(p []byte) (int, error) {
+	if cr.r.done {
+		return 0, io.EOF
+	}
+	if cr.remaining <= 0 {
+		err := cr.r.skipToFrom()
+		if err == io.EOF {
+			cr.r.end(true)
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+		cr.r.end(false)
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.br.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		cr.r.end(true)
+		if cr.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// messageReader reads a message delimited by From lines, unquoting lines if
+// necessary.
+type messageReader struct {
+	r       *reader
+	unquote func(line []byte) []byte
+	buf     []byte // unquoted data not returned yet
+	blank   []byte // held back blank line, which might precede a From line
+}
+
+func (mr *messageReader) Read(p []byte) (int, error) {
+	for len(mr.buf) == 0 {
+		if mr.r.done {
+			return 0, io.EOF
+		}
+
+		line, err := mr.r.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if isFromLine(line) {
+			mr.r.end(false)
+			return 0, io.EOF
+		} else if len(line) == 0 {
+			// A trailing blank line is part of the separator as well
+			mr.r.end(true)
+			return 0, io.EOF
+		}
+
+		// The blank line preceding a From line is part of the separator
+		if mr.blank != nil {
+			mr.buf = append(mr.buf, mr.blank...)
+			mr.blank = nil
+		}
+		if isBlankLine(line) && err == nil {
+			mr.blank = line
+		} else {
+			if mr.unquote != nil {
+				line = mr.unquote(line)
+			}
+			mr.buf = append(mr.buf, line...)
+		}
+		if err == io.EOF {
+			mr.r.end(true)
+		}
+	}
+
+	n := copy(p, mr.buf)
+	mr.buf = mr.buf[n:]
+	return n, nil
+}
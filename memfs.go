@@ -0,0 +1,387 @@
+package maildir
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNotEmpty = errors.New("directory not empty")
+
+// MemFS is an in-memory FS. It is safe for concurrent use.
+//
+// Hard links are supported. Directories need to be created with Mkdir before
+// files can be stored in them, the root directory always exists.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode // by cleaned path
+}
+
+var _ ChtimesFS = (*MemFS)(nil)
+
+type memNode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// NewMemFS creates a new empty in-memory FS.
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{
+		nodes: map[string]*memNode{
+			".":                        {mode: fs.ModeDir | 0700, modTime: now},
+			string(filepath.Separator): {mode: fs.ModeDir | 0700, modTime: now},
+		},
+	}
+}
+
+// lookup returns the node of a file. The caller must hold fsys.mu.
+func (fsys *MemFS) lookup(op, name string) (string, *memNode, error) {
+	name = filepath.Clean(name)
+	node, ok := fsys.nodes[name]
+	if !ok {
+		return name, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, node, nil
+}
+
+// checkParent checks that the parent of a file is an existing directory. The
+// caller must hold fsys.mu.
+func (fsys *MemFS) checkParent(name string) error {
+	parent, ok := fsys.nodes[filepath.Dir(name)]
+	if !ok {
+		return fs.ErrNotExist
+	} else if !parent.mode.IsDir() {
+		return errors.New("not a directory")
+	}
+	return nil
+}
+
+// children returns the sorted names of the entries of a directory. The caller
+// must hold fsys.mu.
+func (fsys *MemFS) children(name string) []string {
+	var names []string
+	for path := range fsys.nodes {
+		if path != name && filepath.Dir(path) == name {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fsys *MemFS) Open(name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fsys *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path := filepath.Clean(name)
+	node, ok := fsys.nodes[path]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		if err := fsys.checkParent(path); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		node = &memNode{mode: perm & fs.ModePerm, modTime: time.Now()}
+		fsys.nodes[path] = node
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if node.mode.IsDir() {
+		if writable {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+		}
+		return &memFile{fsys: fsys, name: name, path: path, node: node, flag: flag, entries: fsys.children(path)}, nil
+	}
+	if flag&os.O_TRUNC != 0 && writable {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	return &memFile{fsys: fsys, name: name, path: path, node: node, flag: flag}, nil
+}
+
+func (fsys *MemFS) Rename(oldpath, newpath string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	node, ok := fsys.nodes[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	if err := fsys.checkParent(newpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	if oldpath == newpath {
+		return nil
+	}
+	if dst, ok := fsys.nodes[newpath]; ok {
+		if node.mode.IsDir() != dst.mode.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+		} else if dst.mode.IsDir() && len(fsys.children(newpath)) > 0 {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errNotEmpty}
+		}
+	}
+
+	if node.mode.IsDir() {
+		if strings.HasPrefix(newpath, oldpath+string(filepath.Separator)) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("invalid argument")}
+		}
+		prefix := oldpath + string(filepath.Separator)
+		for path, child := range fsys.nodes {
+			if strings.HasPrefix(path, prefix) {
+				delete(fsys.nodes, path)
+				fsys.nodes[filepath.Join(newpath, path[len(prefix):])] = child
+			}
+		}
+	}
+	delete(fsys.nodes, oldpath)
+	fsys.nodes[newpath] = node
+	return nil
+}
+
+func (fsys *MemFS) Link(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	node, ok := fsys.nodes[oldname]
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	} else if node.mode.IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrPermission}
+	}
+	if _, ok := fsys.nodes[newname]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if err := fsys.checkParent(newname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	fsys.nodes[newname] = node
+	return nil
+}
+
+func (fsys *MemFS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path, node, err := fsys.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() && len(fsys.children(path)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(fsys.nodes, path)
+	return nil
+}
+
+func (fsys *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
+
+func (fsys *MemFS) Stat(name string) (fs.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path, node, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.stat(filepath.Base(path)), nil
+}
+
+func (fsys *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path := filepath.Clean(name)
+	if _, ok := fsys.nodes[path]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := fsys.checkParent(path); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	fsys.nodes[path] = &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+	return nil
+}
+
+// Chtimes changes the modification time of a file. Access times are not
+// recorded.
+func (fsys *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	_, node, err := fsys.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// stat returns a snapshot of the file information. The caller must hold
+// fsys.mu.
+func (node *memNode) stat(name string) fs.FileInfo {
+	return &memFileInfo{
+		name:    name,
+		size:    int64(len(node.data)),
+		mode:    node.mode,
+		modTime: node.modTime,
+	}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// memFile is an open file or directory of a MemFS.
+type memFile struct {
+	fsys    *MemFS
+	name    string
+	path    string
+	node    *memNode
+	flag    int
+	offset  int64
+	closed  bool
+	entries []string // remaining directory entries
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	case f.node.mode.IsDir():
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	case f.flag&os.O_WRONLY != 0:
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrPermission}
+	case f.offset >= int64(len(f.node.data)):
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	case f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	n := copy(f.node.data[f.offset:], p)
+	f.offset += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.node.stat(filepath.Base(f.path)), nil
+}
+
+func (f *memFile) Sync() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrClosed}
+	case !f.node.mode.IsDir():
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+
+	names := f.entries
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if len(names) > n {
+			names = names[:n]
+		}
+	}
+	f.entries = f.entries[len(names):]
+
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		// Entries removed since the directory was opened are skipped
+		if node, ok := f.fsys.nodes[filepath.Join(f.path, name)]; ok {
+			entries = append(entries, fs.FileInfoToDirEntry(node.stat(name)))
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,121 @@
+package maildir
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFSDir(t *testing.T) {
+	t.Parallel()
+
+	fsys := NewMemFS()
+	d := FSDir{FS: fsys, Path: "/mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	del, err := d.NewDelivery(&DeliveryOptions{Link: true, Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(del, "Hello, world!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := d.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("UnseenCount() = %v, want 1", n)
+	}
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(msgs) != 1 {
+		t.Fatalf("Unseen() returned %v messages, want 1", len(msgs))
+	}
+	if err := msgs[0].SetFlags([]Flag{FlagSeen}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := d.MessageByKey(msgs[0].Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := msg.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != "Hello, world!" {
+		t.Errorf("message content = %q, want %q", b, "Hello, world!")
+	}
+	if size, err := msg.Size(); err != nil {
+		t.Fatal(err)
+	} else if size != 13 {
+		t.Errorf("Size() = %v, want 13", size)
+	}
+
+	target := FSDir{FS: fsys, Path: "/mail/.Archive"}
+	if err := target.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.CopyTo(Dir(target.Path)); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := target.Messages(); err != nil {
+		t.Fatal(err)
+	} else if len(msgs) != 1 || len(msgs[0].Flags()) != 1 || msgs[0].Flags()[0] != FlagSeen {
+		t.Errorf("Messages() in target = %v, want one message with flag S", msgs)
+	}
+
+	if err := msg.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := d.Messages(); err != nil {
+		t.Fatal(err)
+	} else if len(msgs) != 0 {
+		t.Errorf("Messages() = %v, want none", msgs)
+	}
+}
+
+func TestFSDir_Clean(t *testing.T) {
+	t.Parallel()
+
+	fsys := NewMemFS()
+	d := FSDir{FS: fsys, Path: "mail"}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"old", "recent"} {
+		f, err := fsys.OpenFile(filepath.Join("mail", "tmp", name), os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if err := fsys.Chtimes(filepath.Join("mail", "tmp", "old"), time.Time{}, time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat(filepath.Join("mail", "tmp", "old")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() on old file = %v, want ErrNotExist", err)
+	}
+	if _, err := fsys.Stat(filepath.Join("mail", "tmp", "recent")); err != nil {
+		t.Errorf("Stat() on recent file = %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package maildir
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// quotaFilename is the name of the Maildir++ quota file.
+	quotaFilename = "maildirsize"
+	// folderMarker is the name of the file present in Maildir++ folders.
+	folderMarker = "maildirfolder"
+)
+
+const (
+	// quotaMaxFileSize is the size above which the quota file is recalculated.
+	quotaMaxFileSize = 5120
+	// quotaStaleAge is the age after which the quota file is recalculated if
+	// it says the quota is exceeded.
+	quotaStaleAge = 15 * time.Minute
+)
+
+// Quota is a Maildir++ quota definition. A zero field means no limit.
+type Quota struct {
+	Size  int64 // maximum total size of messages, in bytes
+	Count int64 // maximum number of messages
+}
+
+func parseQuota(s string) (Quota, error) {
+	var q Quota
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(part[:len(part)-1], 10, 64)
+		if err != nil || v < 0 {
+			return Quota{}, fmt.Errorf("maildir: invalid quota definition %q", s)
+		}
+		switch part[len(part)-1] {
+		case 'S':
+			q.Size = v
+		case 'C':
+			q.Count = v
+		}
+	}
+	return q, nil
+}
+
+func (q Quota) String() string {
+	var parts []string
+	if q.Size > 0 {
+		parts = append(parts, strconv.FormatInt(q.Size, 10)+"S")
+	}
+	if q.Count > 0 {
+		parts = append(parts, strconv.FormatInt(q.Count, 10)+"C")
+	}
+	return strings.Join(parts, ",")
+}
+
+// exceeded reports whether usage goes over the quota.
+func (q Quota) exceeded(usage QuotaUsage) bool {
+	return (q.Size > 0 && usage.Size > q.Size) || (q.Count > 0 && usage.Count > q.Count)
+}
+
+// QuotaUsage is the storage used by a Maildir++ mailbox.
+type QuotaUsage struct {
+	Size  int64 // total size of messages, in bytes
+	Count int64 // number of messages
+}
+
+// A QuotaError occurs when a delivery would exceed the mailbox quota.
+type QuotaError struct {
+	Quota Quota      // the mailbox quota
+	Usage QuotaUsage // the mailbox usage, including the rejected message
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("maildir: quota exceeded (%v bytes and %v messages used, quota is %q)",
+		e.Usage.Size, e.Usage.Count, e.Quota.String())
+}
+
+// quotaRoot returns the Maildir++ root holding the quota file. Maildir++
+// folders are marked with a maildirfolder file and share the quota of their
+// parent directory.
+func (d Dir) quotaRoot() Dir {
+	if _, err := os.Stat(filepath.Join(string(d), folderMarker)); err == nil {
+		return Dir(filepath.Dir(filepath.Clean(string(d))))
+	}
+	return d
+}
+
+// readQuotaFile parses the quota file of a Maildir++ root.
+func (d Dir) readQuotaFile() (q Quota, usage QuotaUsage, fi os.FileInfo, err error) {
+	f, err := os.Open(filepath.Join(string(d), quotaFilename))
+	if err != nil {
+		return Quota{}, QuotaUsage{}, nil, err
+	}
+	defer f.Close()
+
+	if fi, err = f.Stat(); err != nil {
+		return Quota{}, QuotaUsage{}, nil, err
+	}
+
+	br := bufio.NewReader(io.LimitReader(f, quotaMaxFileSize+1))
+	line, err := br.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Quota{}, QuotaUsage{}, nil, err
+	}
+	if q, err = parseQuota(strings.TrimSpace(line)); err != nil {
+		return Quota{}, QuotaUsage{}, nil, err
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if fields := strings.Fields(line); len(fields) == 2 {
+			size, err1 := strconv.ParseInt(fields[0], 10, 64)
+			count, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 == nil && err2 == nil {
+				usage.Size += size
+				usage.Count += count
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return Quota{}, QuotaUsage{}, nil, err
+		}
+	}
+
+	return q, usage, fi, nil
+}
+
+// Quota returns the Maildir++ quota and current usage of the mailbox.
+//
+// If the Maildir is a Maildir++ folder, the quota of its root is returned. If
+// the mailbox has no quota, a zero Quota is returned. The maildirsize file is
+// recalculated when it has grown too large, or when it says the quota is
+// exceeded but hasn't been recalculated recently.
+func (d Dir) Quota() (Quota, QuotaUsage, error) {
+	root := d.quotaRoot()
+	q, usage, fi, err := root.readQuotaFile()
+	if errors.Is(err, os.ErrNotExist) {
+		return Quota{}, QuotaUsage{}, nil
+	} else if err != nil {
+		return Quota{}, QuotaUsage{}, err
+	}
+
+	stale := fi.Size() > quotaMaxFileSize ||
+		(q.exceeded(usage) && time.Since(fi.ModTime()) > quotaStaleAge)
+	if stale {
+		if usage, err = root.writeQuotaFile(q); err != nil {
+			return Quota{}, QuotaUsage{}, err
+		}
+	}
+
+	return q, usage, nil
+}
+
+// SetQuota sets the Maildir++ quota of the mailbox and recalculates its
+// usage. A zero Quota removes the quota.
+//
+// If the Maildir is a Maildir++ folder, the quota of its root is set.
+func (d Dir) SetQuota(q Quota) error {
+	root := d.quotaRoot()
+	if q == (Quota{}) {
+		err := os.Remove(filepath.Join(string(root), quotaFilename))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	_, err := root.writeQuotaFile(q)
+	return err
+}
+
+// RecalculateQuota recomputes the usage stored in the maildirsize file by
+// scanning all messages in the mailbox. It does nothing if the mailbox has no
+// quota.
+func (d Dir) RecalculateQuota() error {
+	root := d.quotaRoot()
+	q, _, _, err := root.readQuotaFile()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = root.writeQuotaFile(q)
+	return err
+}
+
+// writeQuotaFile atomically replaces the quota file of a Maildir++ root with
+// a freshly calculated usage.
+func (d Dir) writeQuotaFile(q Quota) (QuotaUsage, error) {
+	usage, err := d.calculateQuotaUsage()
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	tmpFilename := filepath.Join(string(d), "tmp", key)
+	f, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	_, err = fmt.Fprintf(f, "%v\n%v %v\n", q.String(), usage.Size, usage.Count)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFilename, filepath.Join(string(d), quotaFilename))
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return QuotaUsage{}, err
+	}
+
+	return usage, nil
+}
+
+// calculateQuotaUsage sums the size of all messages in a Maildir++ root and
+// its folders.
+func (d Dir) calculateQuotaUsage() (QuotaUsage, error) {
+	dirs := []string{string(d)}
+
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() && len(name) > 1 && name[0] == '.' && name != ".." {
+			dirs = append(dirs, filepath.Join(string(d), name))
+		}
+	}
+
+	var usage QuotaUsage
+	for _, dir := range dirs {
+		for _, sub := range []string{"new", "cur"} {
+			entries, err := os.ReadDir(filepath.Join(dir, sub))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return QuotaUsage{}, err
+			}
+			for _, entry := range entries {
+				if entry.Name()[0] == '.' {
+					continue
+				}
+				size, ok := keyAttr(entry.Name(), 'S')
+				if !ok {
+					fi, err := entry.Info()
+					if err != nil {
+						// The message might have been removed in the meantime
+						continue
+					}
+					size = fi.Size()
+				}
+				usage.Size += size
+				usage.Count++
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// checkQuota returns a QuotaError if adding a message of the given size would
+// exceed the quota.
+func (d Dir) checkQuota(size int64) error {
+	q, usage, err := d.Quota()
+	if err != nil {
+		return err
+	}
+	usage.Size += size
+	usage.Count++
+	if q.exceeded(usage) {
+		return &QuotaError{Quota: q, Usage: usage}
+	}
+	return nil
+}
+
+// addQuotaUsage records a change of usage in the quota file, if any. Removed
+// messages are recorded with negative values.
+func (d Dir) addQuotaUsage(size, count int64) error {
+	root := d.quotaRoot()
+	f, err := os.OpenFile(filepath.Join(string(root), quotaFilename), os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%v %v\n", size, count)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
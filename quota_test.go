@@ -0,0 +1,122 @@
+package maildir
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "hello")
+
+	if err := d.SetQuota(Quota{Size: 10, Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	q, usage, err := d.Quota()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q != (Quota{Size: 10, Count: 2}) || usage != (QuotaUsage{Size: 5, Count: 1}) {
+		t.Errorf("Quota() = %+v, %+v", q, usage)
+	}
+
+	// Over the storage limit
+	del, err := NewDelivery(string(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := del.Write([]byte("world!")); err != nil {
+		t.Fatal(err)
+	}
+	var quotaErr *QuotaError
+	if err := del.Close(); !errors.As(err, &quotaErr) {
+		t.Fatalf("Close() = %v, want *QuotaError", err)
+	}
+
+	makeDelivery(t, d, "world")
+	if _, usage, err := d.Quota(); err != nil {
+		t.Fatal(err)
+	} else if usage != (QuotaUsage{Size: 10, Count: 2}) {
+		t.Errorf("Quota() usage = %+v after delivery", usage)
+	}
+	if n, err := d.UnseenCount(); err != nil || n != 2 {
+		t.Errorf("UnseenCount() = %v, %v, want 2", n, err)
+	}
+
+	// Over the message count limit
+	if _, err := NewDelivery(string(d)); !errors.As(err, &quotaErr) {
+		t.Fatalf("NewDelivery() = %v, want *QuotaError", err)
+	}
+
+	if err := d.SetQuota(Quota{}); err != nil {
+		t.Fatal(err)
+	}
+	makeDelivery(t, d, "no more quota")
+}
+
+func TestQuota_remove(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetQuota(Quota{Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, w, err := d.Create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var quotaErr *QuotaError
+	if _, _, err := d.Create(nil); !errors.As(err, &quotaErr) {
+		t.Fatalf("Create() = %v, want *QuotaError", err)
+	}
+
+	if err := msg.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, usage, err := d.Quota(); err != nil {
+		t.Fatal(err)
+	} else if usage != (QuotaUsage{}) {
+		t.Errorf("Quota() usage = %+v after removal, want zero", usage)
+	}
+	makeDelivery(t, d, "world")
+
+	target := Dir(t.TempDir())
+	if err := target.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.SetQuota(Quota{Count: 10}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msgs[0].MoveTo(target); err != nil {
+		t.Fatal(err)
+	}
+	if _, usage, err := d.Quota(); err != nil {
+		t.Fatal(err)
+	} else if usage != (QuotaUsage{}) {
+		t.Errorf("Quota() usage = %+v after move, want zero", usage)
+	}
+	if _, usage, err := target.Quota(); err != nil {
+		t.Fatal(err)
+	} else if usage != (QuotaUsage{Size: 5, Count: 1}) {
+		t.Errorf("Quota() usage of target = %+v after move, want 5 bytes and 1 message", usage)
+	}
+}
@@ -0,0 +1,128 @@
+package maildir
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Reader is a read-only view of a Maildir stored in a fs.FS, such as an
+// embed.FS, a fstest.MapFS or a zip archive.
+//
+// Messages returned by a Reader can be opened, but operations modifying them
+// fail with an error wrapping fs.ErrPermission.
+type Reader struct {
+	d FSDir
+}
+
+// NewReader creates a Reader for the Maildir at dir in fsys. As for fs.FS,
+// dir is a slash-separated path, "." is the root of fsys.
+func NewReader(fsys fs.FS, dir string) *Reader {
+	return &Reader{FSDir{
+		FS:   readOnlyFS{fsys},
+		Path: filepath.FromSlash(dir),
+	}}
+}
+
+// UnseenCount returns the number of messages in new.
+func (r *Reader) UnseenCount() (int, error) {
+	return r.d.UnseenCount()
+}
+
+// Walk calls fn for every message in cur. It behaves like Dir.Walk.
+func (r *Reader) Walk(fn func(*Message) error) error {
+	return r.d.Walk(fn)
+}
+
+// WalkNew calls fn for every message in new. It behaves like Dir.WalkNew.
+func (r *Reader) WalkNew(fn func(*Message) error) error {
+	return r.d.WalkNew(fn)
+}
+
+// Messages returns a list of all messages in cur.
+func (r *Reader) Messages() ([]*Message, error) {
+	return r.d.Messages()
+}
+
+// MessageByKey finds a message by key.
+func (r *Reader) MessageByKey(key string) (*Message, error) {
+	return r.d.MessageByKey(key)
+}
+
+// readOnlyFS adapts a fs.FS to the FS interface. All write operations fail.
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+var _ FS = readOnlyFS{}
+
+// fsPath converts a path built with path/filepath to a fs.FS path.
+func fsPath(name string) string {
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+func (r readOnlyFS) Open(name string) (File, error) {
+	f, err := r.fsys.Open(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{f, name}, nil
+}
+
+func (r readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return r.Open(name)
+}
+
+func (r readOnlyFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrPermission}
+}
+
+func (r readOnlyFS) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (r readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, fsPath(name))
+}
+
+func (r readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, fsPath(name))
+}
+
+func (r readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+// readOnlyFile adapts a fs.File to the File interface.
+type readOnlyFile struct {
+	fs.File
+	name string
+}
+
+func (f *readOnlyFile) Name() string {
+	return f.name
+}
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f *readOnlyFile) Sync() error {
+	return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f *readOnlyFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not implemented")}
+	}
+	return dir.ReadDir(n)
+}
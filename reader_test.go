@@ -0,0 +1,64 @@
+package maildir
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"mail/new/1700000002.M1.example":        {Data: []byte("new")},
+		"mail/cur/1700000000.M1.example:2,S":    {Data: []byte("Hello, world!")},
+		"mail/cur/1700000001.M1.example:2,FRS":  {Data: []byte("flagged")},
+		"mail/cur/.hidden":                      {Data: []byte{}},
+		"mail/cur/1700000003.M1.example:1,beta": {Data: []byte{}},
+		"mail/tmp":                              {Mode: fs.ModeDir},
+	}
+	r := NewReader(fsys, "mail")
+
+	if n, err := r.UnseenCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("UnseenCount() = %v, want 1", n)
+	}
+
+	msgs, err := r.Messages()
+	var flagErr *FlagError
+	if !errors.As(err, &flagErr) || !flagErr.Experimental {
+		t.Errorf("Messages() error = %v, want experimental *FlagError", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("Messages() returned %v messages, want 2", len(msgs))
+	}
+
+	msg, err := r.MessageByKey("1700000000.M1.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := msg.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != "Hello, world!" {
+		t.Errorf("message content = %q, want %q", b, "Hello, world!")
+	}
+
+	if _, err := r.MessageByKey("missing"); err == nil {
+		t.Error("MessageByKey() on missing key succeeded")
+	}
+	if err := msg.SetFlags(nil); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("SetFlags() = %v, want ErrPermission", err)
+	}
+	if err := msg.Remove(); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Remove() = %v, want ErrPermission", err)
+	}
+}
@@ -0,0 +1,430 @@
+// Package uidlist implements Dovecot's dovecot-uidlist file format.
+//
+// The dovecot-uidlist file assigns stable, monotonically increasing IMAP UIDs
+// to the messages of a maildir. It is shared with Dovecot: updates are
+// performed under Dovecot's dotlock and the file is replaced atomically.
+package uidlist
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-maildir"
+	"github.com/emersion/go-maildir/internal/dotlock"
+)
+
+// Filename is the name of the UID list file in a maildir.
+const Filename = "dovecot-uidlist"
+
+// A SyntaxError occurs when a UID list file cannot be parsed.
+type SyntaxError struct {
+	Line int    // the line number, starting at 1
+	Msg  string // description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("uidlist: syntax error on line %v: %v", e.Line, e.Msg)
+}
+
+// Record is an entry of the UID list.
+type Record struct {
+	UID        uint32
+	Extensions []string // extension fields, e.g. "W1234"
+	Filename   string   // message filename, possibly without the info section
+}
+
+// Key returns the maildir key of the record.
+func (rec *Record) Key() string {
+	return keyFromFilename(rec.Filename)
+}
+
+func keyFromFilename(filename string) string {
+	key, _, _ := strings.Cut(filename, ":")
+	return key
+}
+
+// List is the contents of a UID list file.
+type List struct {
+	UIDValidity uint32
+	NextUID     uint32
+	GUID        string // mailbox GUID, hex-encoded
+
+	headerExtensions []string
+	records          []Record // sorted by UID
+	uids             map[string]uint32
+}
+
+// New creates a new empty UID list with a fresh UIDVALIDITY.
+func New() *List {
+	var guid [16]byte
+	if _, err := io.ReadFull(rand.Reader, guid[:]); err != nil {
+		panic(err) // crypto/rand never fails
+	}
+	return &List{
+		UIDValidity: uint32(time.Now().Unix()),
+		NextUID:     1,
+		GUID:        hex.EncodeToString(guid[:]),
+		uids:        make(map[string]uint32),
+	}
+}
+
+// Parse reads a UID list. Versions 1 and 3 of the format are supported.
+func Parse(r io.Reader) (*List, error) {
+	l := &List{uids: make(map[string]uint32)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, &SyntaxError{1, "missing header"}
+	}
+
+	header := strings.Fields(scanner.Text())
+	if len(header) == 0 {
+		return nil, &SyntaxError{1, "missing header"}
+	}
+	version := header[0]
+	switch version {
+	case "1":
+		if len(header) != 3 {
+			return nil, &SyntaxError{1, "invalid header"}
+		}
+		var err error
+		if l.UIDValidity, err = parseUID(header[1]); err != nil {
+			return nil, &SyntaxError{1, "invalid UIDVALIDITY"}
+		}
+		if l.NextUID, err = parseUID(header[2]); err != nil {
+			return nil, &SyntaxError{1, "invalid next UID"}
+		}
+	case "3":
+		for _, field := range header[1:] {
+			var err error
+			switch field[0] {
+			case 'V':
+				l.UIDValidity, err = parseUID(field[1:])
+			case 'N':
+				l.NextUID, err = parseUID(field[1:])
+			case 'G':
+				l.GUID = field[1:]
+			default:
+				l.headerExtensions = append(l.headerExtensions, field)
+			}
+			if err != nil {
+				return nil, &SyntaxError{1, fmt.Sprintf("invalid header field %q", field)}
+			}
+		}
+	default:
+		return nil, &SyntaxError{1, fmt.Sprintf("unsupported version %q", version)}
+	}
+
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		uidStr, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, &SyntaxError{lineNum, "missing filename"}
+		}
+		uid, err := parseUID(uidStr)
+		if err != nil {
+			return nil, &SyntaxError{lineNum, "invalid UID"}
+		}
+
+		rec := Record{UID: uid}
+		if version == "1" {
+			rec.Filename = rest
+		} else {
+			var exts string
+			exts, rec.Filename, ok = strings.Cut(rest, ":")
+			if !ok {
+				return nil, &SyntaxError{lineNum, "missing filename"}
+			}
+			rec.Extensions = strings.Fields(exts)
+		}
+		if rec.Filename == "" {
+			return nil, &SyntaxError{lineNum, "empty filename"}
+		}
+		if n := len(l.records); n > 0 && l.records[n-1].UID >= uid {
+			return nil, &SyntaxError{lineNum, "UIDs are not increasing"}
+		}
+
+		l.records = append(l.records, rec)
+		l.uids[rec.Key()] = uid
+		if uid >= l.NextUID {
+			l.NextUID = uid + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func parseUID(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint32(v), err
+}
+
+// WriteTo writes the UID list using version 3 of the format.
+func (l *List) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	fmt.Fprintf(cw, "3 V%v N%v", l.UIDValidity, l.NextUID)
+	if l.GUID != "" {
+		fmt.Fprintf(cw, " G%v", l.GUID)
+	}
+	for _, ext := range l.headerExtensions {
+		fmt.Fprintf(cw, " %v", ext)
+	}
+	io.WriteString(cw, "\n")
+
+	for _, rec := range l.records {
+		fmt.Fprintf(cw, "%v", rec.UID)
+		for _, ext := range rec.Extensions {
+			fmt.Fprintf(cw, " %v", ext)
+		}
+		fmt.Fprintf(cw, " :%v\n", rec.Filename)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// Records returns all records of the list, sorted by UID.
+func (l *List) Records() []Record {
+	return append([]Record(nil), l.records...)
+}
+
+// Len returns the number of records in the list.
+func (l *List) Len() int {
+	return len(l.records)
+}
+
+// UID returns the UID assigned to a message key.
+func (l *List) UID(key string) (uint32, bool) {
+	uid, ok := l.uids[key]
+	return uid, ok
+}
+
+// Key returns the message key with the given UID.
+func (l *List) Key(uid uint32) (string, bool) {
+	i := sort.Search(len(l.records), func(i int) bool {
+		return l.records[i].UID >= uid
+	})
+	if i < len(l.records) && l.records[i].UID == uid {
+		return l.records[i].Key(), true
+	}
+	return "", false
+}
+
+// Add assigns the next UID to a message key and returns it. If the key
+// already has a UID, it is returned unchanged.
+func (l *List) Add(key string) uint32 {
+	if uid, ok := l.uids[key]; ok {
+		return uid
+	}
+	if l.NextUID == 0 {
+		l.NextUID = 1
+	}
+	uid := l.NextUID
+	l.NextUID++
+	l.records = append(l.records, Record{UID: uid, Filename: key})
+	l.uids[key] = uid
+	return uid
+}
+
+// AddMessages assigns UIDs to messages which don't have one yet, such as
+// the ones returned by Dir.Unseen. New UIDs are assigned in key order.
+func (l *List) AddMessages(msgs []*maildir.Message) {
+	var keys []string
+	for _, msg := range msgs {
+		if _, ok := l.uids[msg.Key()]; !ok {
+			keys = append(keys, msg.Key())
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l.Add(key)
+	}
+}
+
+// Remove removes a message key from the list. The UID is never reused.
+func (l *List) Remove(key string) bool {
+	uid, ok := l.uids[key]
+	if !ok {
+		return false
+	}
+	delete(l.uids, key)
+	i := sort.Search(len(l.records), func(i int) bool {
+		return l.records[i].UID >= uid
+	})
+	l.records = append(l.records[:i], l.records[i+1:]...)
+	return true
+}
+
+// Load reads the UID list of a maildir without locking it. If the maildir
+// has no UID list yet, an empty list is returned.
+func Load(d maildir.Dir) (*List, error) {
+	f, err := os.Open(filepath.Join(string(d), Filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Update locks the UID list of a maildir, calls fn with its current contents
+// and atomically replaces the file with the updated list.
+//
+// If fn returns an error, the file is left untouched and the error is
+// returned.
+func Update(d maildir.Dir, fn func(l *List) error) error {
+	path := filepath.Join(string(d), Filename)
+	lock, err := dotlock.Create(path + ".lock")
+	if err != nil {
+		return err
+	}
+	published := false
+	defer func() {
+		if !published {
+			lock.Close()
+			os.Remove(lock.Name())
+		}
+	}()
+
+	l, err := Load(d)
+	if err != nil {
+		return err
+	}
+	if err := fn(l); err != nil {
+		return err
+	}
+
+	if _, err := l.WriteTo(lock); err != nil {
+		return err
+	}
+	if err := lock.Sync(); err != nil {
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(lock.Name(), path); err != nil {
+		return err
+	}
+	published = true
+	return nil
+}
+
+// Sync assigns UIDs to all messages in the maildir which don't have one yet,
+// and removes records of messages which no longer exist.
+//
+// Messages in both new and cur are assigned a UID. Files with a malformed
+// name in cur are ignored.
+func Sync(d maildir.Dir) (*List, error) {
+	var result *List
+	err := Update(d, func(l *List) error {
+		// Scan new before cur, so that messages concurrently moved from new
+		// to cur are not missed
+		entries, err := os.ReadDir(filepath.Join(string(d), "new"))
+		if err != nil {
+			return err
+		}
+		present := make(map[string]bool)
+		var keys []string
+		for _, entry := range entries {
+			if entry.Name()[0] == '.' {
+				continue
+			}
+			key := keyFromFilename(entry.Name())
+			present[key] = true
+			keys = append(keys, key)
+		}
+
+		err = d.Walk(func(msg *maildir.Message) error {
+			present[msg.Key()] = true
+			keys = append(keys, msg.Key())
+			return nil
+		})
+		if err := ignoreFormatErrors(err); err != nil {
+			return err
+		}
+
+		for _, rec := range l.Records() {
+			if !present[rec.Key()] {
+				l.Remove(rec.Key())
+			}
+		}
+
+		sort.Strings(keys)
+		for _, key := range keys {
+			l.Add(key)
+		}
+
+		result = l
+		return nil
+	})
+	return result, err
+}
+
+// ignoreFormatErrors drops malformed file errors returned by Dir.Walk.
+func ignoreFormatErrors(err error) error {
+	if err == nil {
+		return nil
+	}
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+
+	var kept []error
+	for _, err := range errs {
+		var mailfileErr *maildir.MailfileError
+		var flagErr *maildir.FlagError
+		if errors.As(err, &mailfileErr) || errors.As(err, &flagErr) {
+			continue
+		}
+		kept = append(kept, err)
+	}
+	return errors.Join(kept...)
+}
@@ -0,0 +1,107 @@
+package uidlist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-maildir"
+)
+
+const testList = `3 V1275660208 N25022 G3085f01b7f11094c501100008c4a11c1
+25006 :1276528487.M364837P9451.kurkku,S=1355,W=1394:2,
+25017 W2481 :1276533073.M242911P3632.kurkku:2,F
+`
+
+func TestParse(t *testing.T) {
+	l, err := Parse(strings.NewReader(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.UIDValidity != 1275660208 || l.NextUID != 25022 || l.GUID != "3085f01b7f11094c501100008c4a11c1" {
+		t.Errorf("Parse() = %+v, wrong header", l)
+	}
+	if uid, ok := l.UID("1276533073.M242911P3632.kurkku"); !ok || uid != 25017 {
+		t.Errorf("UID() = %v, %v, want 25017", uid, ok)
+	}
+	if key, ok := l.Key(25006); !ok || key != "1276528487.M364837P9451.kurkku,S=1355,W=1394" {
+		t.Errorf("Key() = %q, %v", key, ok)
+	}
+
+	var sb strings.Builder
+	if _, err := l.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != testList {
+		t.Errorf("WriteTo() = %q, want %q", sb.String(), testList)
+	}
+}
+
+func TestParse_v1(t *testing.T) {
+	l, err := Parse(strings.NewReader("1 1000 3\n1 foo:2,S\n2 bar\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.UIDValidity != 1000 || l.NextUID != 3 || l.Len() != 2 {
+		t.Errorf("Parse() = %+v", l)
+	}
+	if uid, ok := l.UID("foo"); !ok || uid != 1 {
+		t.Errorf("UID() = %v, %v, want 1", uid, ok)
+	}
+}
+
+func TestSync(t *testing.T) {
+	d := maildir.Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func() {
+		del, err := maildir.NewDelivery(string(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := del.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliver()
+	deliver()
+
+	l, err := Sync(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 || l.NextUID != 3 {
+		t.Fatalf("Sync() = %+v, want 2 records", l)
+	}
+	uidValidity := l.UIDValidity
+
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msgs[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	deliver()
+
+	l, err = Sync(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 || l.NextUID != 4 || l.UIDValidity != uidValidity {
+		t.Errorf("Sync() = %+v, want 2 records and UIDVALIDITY %v", l, uidValidity)
+	}
+	if _, ok := l.UID(msgs[0].Key()); ok {
+		t.Errorf("removed message still has a UID")
+	}
+	if uid, ok := l.UID(msgs[1].Key()); !ok || uid > 2 {
+		t.Errorf("UID() = %v, %v, want a stable UID", uid, ok)
+	}
+
+	if _, err := os.Stat(filepath.Join(string(d), Filename+".lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file was not released")
+	}
+}
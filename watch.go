@@ -0,0 +1,248 @@
+package maildir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pollInterval is the delay between two scans when the Maildir is watched by
+// polling.
+var pollInterval = time.Second
+
+// EventOp describes a change to a message.
+type EventOp int
+
+const (
+	// A message has been delivered to new.
+	EventNew EventOp = iota + 1
+	// A message has appeared in cur, usually moved from new.
+	EventCur
+	// The flags of a message in cur have changed.
+	EventFlags
+	// A message has been removed from new or cur.
+	EventRemoved
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventNew:
+		return "new"
+	case EventCur:
+		return "cur"
+	case EventFlags:
+		return "flags"
+	case EventRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change to a message of a watched Maildir.
+type Event struct {
+	Op  EventOp
+	Key string // the message key
+	// The message after the change, nil for EventRemoved. Messages in new
+	// have no flags.
+	Message *Message
+}
+
+// Watcher delivers events about changes in a Maildir.
+type Watcher struct {
+	events chan Event
+	err    error
+}
+
+// Events returns the channel on which events are delivered. The channel is
+// closed when the context passed to Dir.Watch is done or when an error occurs.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns the error which stopped the watcher, if any. It must only be
+// called after the events channel has been closed.
+//
+// If events have been lost, ErrWatchOverflow is returned: the Maildir needs
+// to be rescanned. If new or cur is removed, an error wrapping
+// fs.ErrNotExist is returned.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// ErrWatchOverflow is returned by Watcher.Err when the system dropped events,
+// for instance because too many changes happened at once.
+var ErrWatchOverflow = errors.New("maildir: watch event queue overflow")
+
+// notifier reports changes in a Maildir until the context is done.
+type notifier interface {
+	run(ctx context.Context, emit func(Event) bool) error
+}
+
+// Watch starts watching the new and cur directories of the Maildir for
+// changes.
+//
+// On Linux, inotify is used. On other systems, or if inotify is unavailable,
+// the directories are polled and rescanned when their modification time
+// changes.
+func (d Dir) Watch(ctx context.Context) (*Watcher, error) {
+	n, err := d.newNotifier()
+	if err != nil {
+		p, pollErr := d.newPoller()
+		if pollErr != nil {
+			return nil, pollErr
+		}
+		n = p
+	}
+
+	w := &Watcher{events: make(chan Event)}
+	go func() {
+		defer close(w.events)
+		err := n.run(ctx, func(ev Event) bool {
+			select {
+			case w.events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+			w.err = err
+		}
+	}()
+	return w, nil
+}
+
+// eventMessage returns the message of an event for a file in new or cur.
+func (d Dir) eventMessage(sub, basename string) (*Message, error) {
+	if sub == "new" {
+		return d.fsDir().newUnseenMessage(basename), nil
+	}
+	return d.fsDir().newMessage(filepath.Join(string(d), "cur"), basename)
+}
+
+// poller watches a Maildir by polling its directories.
+type poller struct {
+	d        Dir
+	modTimes map[string]time.Time
+	files    map[string]polledFile // by key
+}
+
+type polledFile struct {
+	sub      string
+	basename string
+}
+
+func (d Dir) newPoller() (*poller, error) {
+	p := &poller{d: d}
+	files, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	p.files = files
+	return p, nil
+}
+
+// list returns the files in new and cur, or nil if the directories haven't
+// changed since the last call.
+func (p *poller) list() (map[string]polledFile, error) {
+	modTimes := make(map[string]time.Time)
+	changed := p.modTimes == nil
+	for _, sub := range []string{"new", "cur"} {
+		fi, err := os.Stat(filepath.Join(string(p.d), sub))
+		if err != nil {
+			return nil, err
+		}
+		modTimes[sub] = fi.ModTime()
+		// Directories modified very recently might be modified again without
+		// their modification time changing, depending on its granularity
+		if !fi.ModTime().Equal(p.modTimes[sub]) || time.Since(fi.ModTime()) < 2*time.Second {
+			changed = true
+		}
+	}
+	p.modTimes = modTimes
+	if !changed {
+		return nil, nil
+	}
+
+	files := make(map[string]polledFile)
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(string(p.d), sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if name[0] == '.' {
+				continue
+			}
+			key, _, _ := strings.Cut(name, string(separator))
+			files[key] = polledFile{sub, name}
+		}
+	}
+	return files, nil
+}
+
+// diff reports the changes between two lists of files. It returns false if
+// emit did.
+func (p *poller) diff(prev, files map[string]polledFile, emit func(Event) bool) bool {
+	for key, f := range files {
+		old, existed := prev[key]
+		var op EventOp
+		switch {
+		case f.sub == "new" && !existed:
+			op = EventNew
+		case f.sub == "cur" && (!existed || old.sub == "new"):
+			op = EventCur
+		case f.sub == "cur" && old.basename != f.basename:
+			op = EventFlags
+		default:
+			continue
+		}
+		msg, err := p.d.eventMessage(f.sub, f.basename)
+		if err != nil {
+			// Ignore malformed files
+			continue
+		}
+		if !emit(Event{Op: op, Key: key, Message: msg}) {
+			return false
+		}
+	}
+	for key := range prev {
+		if _, ok := files[key]; !ok {
+			if !emit(Event{Op: EventRemoved, Key: key}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *poller) run(ctx context.Context, emit func(Event) bool) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		files, err := p.list()
+		if err != nil {
+			return err
+		}
+		if files == nil {
+			continue
+		}
+		prev := p.files
+		p.files = files
+		if !p.diff(prev, files, emit) {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build linux
+
+package maildir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifier watches a Maildir with inotify.
+type inotifier struct {
+	d    Dir
+	file *os.File
+	subs map[int32]string // subdirectory by watch descriptor
+}
+
+func (d Dir) newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// The file descriptor is non-blocking, so reads go through the runtime
+	// poller and are interrupted when the file is closed
+	n := &inotifier{
+		d:    d,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		subs: make(map[int32]string),
+	}
+
+	const mask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+		syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+	for _, sub := range []string{"new", "cur"} {
+		path := filepath.Join(string(d), sub)
+		wd, err := syscall.InotifyAddWatch(fd, path, mask)
+		if err != nil {
+			n.file.Close()
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		n.subs[int32(wd)] = sub
+	}
+
+	return n, nil
+}
+
+func (n *inotifier) run(ctx context.Context, emit func(Event) bool) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		n.file.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		nr, err := n.file.Read(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return err
+		}
+		if ok, err := n.handle(buf[:nr], emit); err != nil {
+			return err
+		} else if !ok {
+			return ctx.Err()
+		}
+	}
+}
+
+type inotifyMove struct {
+	sub, name string
+}
+
+// handle reports the changes contained in a buffer of inotify events. It
+// returns false if emit did. An error is returned if events have been lost
+// or if a watched directory is gone.
+func (n *inotifier) handle(buf []byte, emit func(Event) bool) (bool, error) {
+	send := func(op EventOp, sub, name string) bool {
+		key, _, _ := strings.Cut(name, string(separator))
+		ev := Event{Op: op, Key: key}
+		if op != EventRemoved {
+			msg, err := n.d.eventMessage(sub, name)
+			if err != nil {
+				// Ignore malformed files
+				return true
+			}
+			ev.Message = msg
+		}
+		return emit(ev)
+	}
+
+	// Renames generate a pair of events sharing a cookie
+	moves := make(map[uint32]inotifyMove)
+	var cookies []uint32
+
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := syscall.SizeofInotifyEvent + int(raw.Len)
+		if end > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[syscall.SizeofInotifyEvent:end]), "\x00")
+		buf = buf[end:]
+
+		if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			return false, ErrWatchOverflow
+		}
+		sub, ok := n.subs[raw.Wd]
+		if ok && raw.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+			return false, &os.PathError{Op: "watch", Path: filepath.Join(string(n.d), sub), Err: os.ErrNotExist}
+		}
+		if !ok || name == "" || name[0] == '.' || raw.Mask&syscall.IN_ISDIR != 0 {
+			continue
+		}
+
+		op := EventNew
+		if sub == "cur" {
+			op = EventCur
+		}
+
+		switch {
+		case raw.Mask&syscall.IN_MOVED_FROM != 0:
+			moves[raw.Cookie] = inotifyMove{sub, name}
+			cookies = append(cookies, raw.Cookie)
+		case raw.Mask&syscall.IN_MOVED_TO != 0:
+			if from, ok := moves[raw.Cookie]; ok {
+				delete(moves, raw.Cookie)
+				fromKey, _, _ := strings.Cut(from.name, string(separator))
+				toKey, _, _ := strings.Cut(name, string(separator))
+				if fromKey != toKey {
+					if !send(EventRemoved, from.sub, from.name) {
+						return false, nil
+					}
+				} else if from.sub == "cur" && sub == "cur" {
+					op = EventFlags
+				}
+			}
+			if !send(op, sub, name) {
+				return false, nil
+			}
+		case raw.Mask&syscall.IN_CREATE != 0:
+			if !send(op, sub, name) {
+				return false, nil
+			}
+		case raw.Mask&syscall.IN_DELETE != 0:
+			if !send(EventRemoved, sub, name) {
+				return false, nil
+			}
+		}
+	}
+
+	// Files renamed out of the Maildir
+	for _, cookie := range cookies {
+		if from, ok := moves[cookie]; ok {
+			if !send(EventRemoved, from.sub, from.name) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
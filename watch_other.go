@@ -0,0 +1,11 @@
+//go:build !linux
+
+package maildir
+
+import (
+	"errors"
+)
+
+func (d Dir) newNotifier() (notifier, error) {
+	return nil, errors.New("maildir: native file watching is not supported")
+}
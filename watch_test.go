@@ -0,0 +1,139 @@
+package maildir
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testWatch(t *testing.T, d Dir, events <-chan Event) {
+	next := func(want EventOp) *Message {
+		t.Helper()
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("events channel closed, want %v", want)
+			}
+			if ev.Op != want {
+				t.Fatalf("got %v event, want %v", ev.Op, want)
+			}
+			if (ev.Message == nil) != (want == EventRemoved) {
+				t.Fatalf("%v event has message %v", ev.Op, ev.Message)
+			}
+			return ev.Message
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v event", want)
+			return nil
+		}
+	}
+
+	makeDelivery(t, d, "hello")
+	next(EventNew)
+
+	msgs, err := d.Unseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(EventCur); msg.Key() != msgs[0].Key() {
+		t.Errorf("got key %q, want %q", msg.Key(), msgs[0].Key())
+	}
+
+	if err := msgs[0].SetFlags([]Flag{FlagSeen}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(EventFlags); len(msg.Flags()) != 1 || msg.Flags()[0] != FlagSeen {
+		t.Errorf("got flags %q, want [S]", msg.Flags())
+	}
+
+	if err := msgs[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	next(EventRemoved)
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWatch(t, d, w.Events())
+
+	cancel()
+	for range w.Events() {
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestWatch_poll(t *testing.T) {
+	// don't run this test in // as it modifies a package variable
+	previousPollInterval := pollInterval
+	pollInterval = 10 * time.Millisecond
+	defer func() {
+		pollInterval = previousPollInterval
+	}()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := d.newPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan Event, 16)
+	go p.run(ctx, func(ev Event) bool {
+		events <- ev
+		return true
+	})
+
+	testWatch(t, d, events)
+}
+
+func TestWatch_removed(t *testing.T) {
+	t.Parallel()
+
+	d := Dir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(string(d), "cur")); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case _, ok := <-w.Events():
+			done = !ok
+		case <-timeout:
+			t.Fatal("timed out waiting for the watcher to stop")
+		}
+	}
+	if err := w.Err(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Err() = %v, want ErrNotExist", err)
+	}
+}